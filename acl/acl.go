@@ -0,0 +1,139 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// List decides which remote addresses may connect. Deny entries always
+// win; when the allow list is not empty an address must match it too.
+type List struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// New builds a List from CIDR prefixes or single addresses
+func New(allow, deny []string) (*List, error) {
+	l := &List{}
+
+	var err error
+	if l.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if l.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// ParsePrefix parses a CIDR prefix such as 10.0.0.0/8 or fd00::/8. A single
+// address is a prefix matching only itself.
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid ACL entry %q: %w", entry, err)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ACL entry %q: %w", entry, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Split parses a comma separated list of entries, as given on the command line
+func Split(entries string) []string {
+	var result []string
+	for _, entry := range strings.Split(entries, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ReadFile reads one entry per line, ignoring blank lines and # comments
+func ReadFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Check reports whether addr may connect, with the reason of the decision
+func (l *List) Check(addr netip.Addr) (bool, string) {
+	addr = addr.Unmap()
+
+	for _, prefix := range l.deny {
+		if prefix.Contains(addr) {
+			return false, "denied by " + prefix.String()
+		}
+	}
+
+	if len(l.allow) == 0 {
+		return true, "no allow list"
+	}
+	for _, prefix := range l.allow {
+		if prefix.Contains(addr) {
+			return true, "allowed by " + prefix.String()
+		}
+	}
+	return false, "not in allow list"
+}
+
+// CheckAddr is Check for the address of a connection. Addresses without an
+// IP, such as Unix sockets, are rejected.
+func (l *List) CheckAddr(addr net.Addr) (bool, string) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	case nil:
+	default:
+		if host, _, err := net.SplitHostPort(a.String()); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+
+	parsed, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false, "no IP address"
+	}
+	return l.Check(parsed)
+}
@@ -0,0 +1,120 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mustNew(t *testing.T, allow, deny []string) *List {
+	t.Helper()
+	l, err := New(allow, deny)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return l
+}
+
+func TestCheckIPv4(t *testing.T) {
+	l := mustNew(t, []string{"10.0.0.0/8", "192.168.1.5"}, []string{"10.1.0.0/16"})
+
+	tests := map[string]bool{
+		"10.2.3.4":    true,  // allowed range
+		"10.1.2.3":    false, // deny wins over allow
+		"192.168.1.5": true,  // single address
+		"192.168.1.6": false, // not in the allow list
+		"8.8.8.8":     false,
+	}
+	for addr, want := range tests {
+		if got, reason := l.Check(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Check(%s) = %v (%s), want %v", addr, got, reason, want)
+		}
+	}
+}
+
+func TestCheckIPv6(t *testing.T) {
+	l := mustNew(t, []string{"fd00::/8", "::1"}, []string{"fd00:bad::/32"})
+
+	tests := map[string]bool{
+		"fd00::1":     true,
+		"fd00:bad::1": false,
+		"::1":         true,
+		"2001:db8::1": false,
+	}
+
+	for addr, want := range tests {
+		if got, reason := l.Check(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Check(%s) = %v (%s), want %v", addr, got, reason, want)
+		}
+	}
+}
+
+func TestCheckMappedIPv4(t *testing.T) {
+	// A dual stack listener reports IPv4 clients as IPv4-mapped IPv6 addresses
+	l := mustNew(t, []string{"127.0.0.0/8"}, nil)
+
+	if ok, _ := l.Check(netip.MustParseAddr("::ffff:127.0.0.1")); !ok {
+		t.Error("expected the mapped loopback address to match the IPv4 prefix")
+	}
+}
+
+func TestDenyOnly(t *testing.T) {
+	l := mustNew(t, nil, []string{"203.0.113.0/24"})
+
+	if ok, _ := l.Check(netip.MustParseAddr("198.51.100.1")); !ok {
+		t.Error("without an allow list everything not denied is allowed")
+	}
+	if ok, _ := l.Check(netip.MustParseAddr("203.0.113.9")); ok {
+		t.Error("expected the denied range to be rejected")
+	}
+}
+
+func TestCheckAddr(t *testing.T) {
+	l := mustNew(t, []string{"127.0.0.1"}, nil)
+
+	if ok, _ := l.CheckAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}); !ok {
+		t.Error("expected the TCP address to be allowed")
+	}
+	if ok, _ := l.CheckAddr(&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}); ok {
+		t.Error("expected an address without IP to be rejected")
+	}
+	if ok, _ := l.CheckAddr(nil); ok {
+		t.Error("expected a nil address to be rejected")
+	}
+}
+
+func TestInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "not-an-ip", "300.1.1.1"} {
+		if _, err := New([]string{entry}, nil); err == nil {
+			t.Errorf("expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	got := Split(" 10.0.0.0/8, ,::1,")
+	want := []string{"10.0.0.0/8", "::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Split = %v, want %v", got, want)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	content := "# office\n10.0.0.0/8\n\n  192.168.1.5   # printer\nfd00::/8\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadFile = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Algorithm names a compression format
+type Algorithm string
+
+const (
+	None  Algorithm = ""
+	Gzip  Algorithm = "gzip"
+	Zlib  Algorithm = "zlib"
+	Flate Algorithm = "flate"
+)
+
+// Algorithms lists the supported formats. They all come from the standard
+// library, which has no zstd implementation.
+var Algorithms = []Algorithm{Gzip, Zlib, Flate}
+
+// preamble is sent by both peers before any data: magic, length, algorithm name
+var preamble = []byte("GONCZ")
+
+// NegotiateTimeout bounds how long Wrap waits for the peer preamble
+var NegotiateTimeout = 10 * time.Second
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown compression algorithm")
+	ErrMismatch         = errors.New("peer uses a different compression setting")
+)
+
+// Parse validates an algorithm name
+func Parse(name string) (Algorithm, error) {
+	for _, algo := range Algorithms {
+		if string(algo) == name {
+			return algo, nil
+		}
+	}
+	return None, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+}
+
+// Negotiate exchanges the preamble with the peer and fails when the peer
+// does not send one or asks for a different algorithm.
+func Negotiate(conn net.Conn, algo Algorithm) error {
+	ours := append([]byte{}, preamble...)
+	ours = append(ours, byte(len(algo)))
+	ours = append(ours, algo...)
+
+	// Both sides write first, so the write must not wait for the peer to read
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(ours)
+		errChan <- err
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(NegotiateTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	theirs := make([]byte, len(preamble)+1)
+	if _, err := io.ReadFull(conn, theirs); err != nil {
+		return fmt.Errorf("error reading compression preamble: %w", err)
+	}
+	if !bytes.Equal(theirs[:len(preamble)], preamble) {
+		return fmt.Errorf("%w: no compression preamble received", ErrMismatch)
+	}
+
+	name := make([]byte, theirs[len(preamble)])
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return fmt.Errorf("error reading compression preamble: %w", err)
+	}
+	if Algorithm(name) != algo {
+		return fmt.Errorf("%w: local %q, remote %q", ErrMismatch, algo, name)
+	}
+
+	return <-errChan
+}
+
+// flushWriter is implemented by all the compressors in use
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Conn compresses writes and decompresses reads on top of a net.Conn.
+// Every write is flushed so interactive sessions are not held back.
+type Conn struct {
+	net.Conn
+	algo Algorithm
+
+	wmu sync.Mutex
+	w   flushWriter
+
+	r io.Reader // created on first read, gzip and zlib block on their header
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Wrap negotiates algo with the peer and returns the compressed connection
+func Wrap(conn net.Conn, algo Algorithm) (*Conn, error) {
+	if err := Negotiate(conn, algo); err != nil {
+		return nil, err
+	}
+
+	c := &Conn{Conn: conn, algo: algo}
+	switch algo {
+	case Gzip:
+		c.w = gzip.NewWriter(conn)
+	case Zlib:
+		c.w = zlib.NewWriter(conn)
+	case Flate:
+		c.w, _ = flate.NewWriter(conn, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algo)
+	}
+	return c, nil
+}
+
+// Read returns decompressed data from the peer
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.r == nil {
+		// Kept only once the header was read, a failed attempt such as a
+		// deadline is retried on the next Read
+		var r io.Reader
+		var err error
+		switch c.algo {
+		case Gzip:
+			r, err = gzip.NewReader(c.Conn)
+		case Zlib:
+			r, err = zlib.NewReader(c.Conn)
+		case Flate:
+			r = flate.NewReader(c.Conn)
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+	return c.r.Read(p)
+}
+
+// Write compresses p and flushes it to the peer
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// Close terminates the compressed stream and closes the connection
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.wmu.Lock()
+		err := c.w.Close()
+		c.wmu.Unlock()
+
+		c.closeErr = c.Conn.Close()
+		if c.closeErr == nil {
+			c.closeErr = err
+		}
+	})
+	return c.closeErr
+}
+
+// NetConn returns the connection carrying the compressed stream
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Handler wraps a server connection handler so it works on the
+// decompressed stream of every accepted connection
+func Handler(algo Algorithm, next func(conn net.Conn, input io.Reader, output io.Writer) error) func(conn net.Conn, input io.Reader, output io.Writer) error {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		compressed, err := Wrap(conn, algo)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		return next(compressed, input, output)
+	}
+}
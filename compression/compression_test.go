@@ -0,0 +1,147 @@
+package compression
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wrapPair negotiates both ends of an in-memory connection
+func wrapPair(t *testing.T, clientAlgo, serverAlgo Algorithm) (*Conn, *Conn, error, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	serverChan := make(chan result, 1)
+	go func() {
+		conn, err := Wrap(serverConn, serverAlgo)
+		serverChan <- result{conn, err}
+	}()
+
+	client, clientErr := Wrap(clientConn, clientAlgo)
+	server := <-serverChan
+	return client, server.conn, clientErr, server.err
+}
+
+func TestParse(t *testing.T) {
+	for _, algo := range Algorithms {
+		if got, err := Parse(string(algo)); err != nil || got != algo {
+			t.Errorf("Parse(%q) = %q, %v", algo, got, err)
+		}
+	}
+
+	if _, err := Parse("zstd"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
+
+func TestInteractiveRoundTrip(t *testing.T) {
+	for _, algo := range Algorithms {
+		t.Run(string(algo), func(t *testing.T) {
+			client, server, clientErr, serverErr := wrapPair(t, algo, algo)
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("negotiation failed: client %v, server %v", clientErr, serverErr)
+			}
+
+			// Each write must be readable before the stream is closed
+			for _, msg := range []string{"hello\n", "second line\n"} {
+				go client.Write([]byte(msg))
+
+				buf := make([]byte, len(msg))
+				server.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := io.ReadFull(server, buf); err != nil {
+					t.Fatalf("error reading %q: %v", msg, err)
+				}
+				if string(buf) != msg {
+					t.Fatalf("expected %q, got %q", msg, buf)
+				}
+			}
+		})
+	}
+}
+
+func TestCloseEndsStream(t *testing.T) {
+	client, server, clientErr, serverErr := wrapPair(t, Gzip, Gzip)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("negotiation failed: client %v, server %v", clientErr, serverErr)
+	}
+
+	payload := strings.Repeat("compress me ", 1000)
+	go func() {
+		client.Write([]byte(payload))
+		client.Close()
+	}()
+
+	got, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != payload {
+		t.Fatalf("expected %d bytes, got %d", len(payload), len(got))
+	}
+}
+
+func TestNegotiationMismatch(t *testing.T) {
+	_, _, clientErr, serverErr := wrapPair(t, Gzip, Flate)
+
+	if !errors.Is(clientErr, ErrMismatch) {
+		t.Errorf("expected client ErrMismatch, got %v", clientErr)
+	}
+	if !errors.Is(serverErr, ErrMismatch) {
+		t.Errorf("expected server ErrMismatch, got %v", serverErr)
+	}
+}
+
+func TestNegotiationWithoutPreamble(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// A plain peer sends data right away and never reads the preamble
+	go serverConn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+	go io.Copy(io.Discard, serverConn)
+
+	_, err := Wrap(clientConn, Gzip)
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+}
+
+// A failed header read must leave the Conn usable, not holding a nil
+// reader. Flate has no header.
+func TestReadAfterFailedHeader(t *testing.T) {
+	for _, algo := range []Algorithm{Gzip, Zlib} {
+		t.Run(string(algo), func(t *testing.T) {
+			client, server, clientErr, serverErr := wrapPair(t, algo, algo)
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("negotiation failed: client %v, server %v", clientErr, serverErr)
+			}
+
+			buf := make([]byte, 16)
+			server.SetReadDeadline(time.Now().Add(-time.Second))
+			if _, err := server.Read(buf); err == nil {
+				t.Fatal("expected the first read to time out")
+			}
+			if _, err := server.Read(buf); err == nil {
+				t.Fatal("expected the second read to time out too")
+			}
+
+			go client.Write([]byte("hello"))
+			server.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(server, buf[:5]); err != nil || string(buf[:5]) != "hello" {
+				t.Fatalf("expected hello after the timeouts, got %q, %v", buf[:5], err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package gonctest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Faults describes the misbehavior injected into a connection. The zero
+// value injects nothing.
+type Faults struct {
+	// ResetAfter resets the connection once this many bytes were read and
+	// written in total. Operations then fail with syscall.ECONNRESET and the
+	// peer sees the connection closed.
+	ResetAfter int
+
+	// ShortWrite makes writes larger than this write only ShortWrite bytes
+	// and fail with io.ErrShortWrite
+	ShortWrite int
+
+	// ReadChunk returns at most this many bytes per read
+	ReadChunk int
+
+	// ReadDelay is waited before every read
+	ReadDelay time.Duration
+}
+
+// Wrap returns conn with the faults injected, or conn itself when there are none
+func (f Faults) Wrap(conn net.Conn) net.Conn {
+	if f == (Faults{}) {
+		return conn
+	}
+	return &faultyConn{Conn: conn, faults: f}
+}
+
+type faultyConn struct {
+	net.Conn
+	faults Faults
+
+	mu          sync.Mutex
+	transferred int
+	reset       bool
+}
+
+// budget returns how many of n bytes may move before the reset, and
+// whether the connection is already reset
+func (c *faultyConn) budget(n int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reset {
+		return 0, true
+	}
+	if c.faults.ResetAfter > 0 {
+		n = min(n, c.faults.ResetAfter-c.transferred)
+	}
+	return n, false
+}
+
+// account records n transferred bytes and resets the connection when the
+// limit is reached
+func (c *faultyConn) account(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.transferred += n
+	if c.faults.ResetAfter > 0 && c.transferred >= c.faults.ResetAfter && !c.reset {
+		c.reset = true
+		c.Conn.Close()
+	}
+}
+
+func (c *faultyConn) resetError(op string) error {
+	return &net.OpError{Op: op, Net: c.LocalAddr().Network(), Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: syscall.ECONNRESET}
+}
+
+func (c *faultyConn) Read(p []byte) (int, error) {
+	if c.faults.ReadDelay > 0 {
+		time.Sleep(c.faults.ReadDelay)
+	}
+	if c.faults.ReadChunk > 0 && len(p) > c.faults.ReadChunk {
+		p = p[:c.faults.ReadChunk]
+	}
+
+	allowed, reset := c.budget(len(p))
+	if reset || (allowed <= 0 && len(p) > 0) {
+		return 0, c.resetError("read")
+	}
+
+	n, err := c.Conn.Read(p[:allowed])
+	c.account(n)
+	return n, err
+}
+
+func (c *faultyConn) Write(p []byte) (int, error) {
+	short := c.faults.ShortWrite > 0 && len(p) > c.faults.ShortWrite
+	if short {
+		p = p[:c.faults.ShortWrite]
+	}
+
+	allowed, reset := c.budget(len(p))
+	if reset {
+		return 0, c.resetError("write")
+	}
+
+	n, err := c.Conn.Write(p[:allowed])
+	c.account(n)
+	switch {
+	case err != nil:
+		return n, err
+	case allowed < len(p):
+		return n, c.resetError("write")
+	case short:
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package gonctest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/network"
+	"github.com/gppmad/gonc/tcp_server"
+)
+
+func TestScriptedServer(t *testing.T) {
+	n := NewNetwork()
+	server := StartServer(t, n, "echo:7", func(s *tcp_server.TcpServer) {
+		s.Handler = NewScript().Expect("ping\n").Send("pong\n").Hangup().Handler()
+	})
+
+	output := new(bytes.Buffer)
+	err := RunClient(context.Background(), n, network.ClientConfig{RemoteAddr: "echo:7"}, strings.NewReader("ping\n"), output)
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+	if output.String() != "pong\n" {
+		t.Errorf("expected pong, got %q", output.String())
+	}
+
+	if got := server.Metrics.Accepted.Load(); got != 1 {
+		t.Errorf("expected 1 accepted connection, got %d", got)
+	}
+}
+
+func TestScriptMismatch(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("HELO"))
+		client.Close()
+	}()
+
+	err := NewScript().Expect("EHLO").Run(server)
+	if err == nil || !strings.Contains(err.Error(), `expected "EHLO", got "HELO"`) {
+		t.Errorf("expected a mismatch error, got %v", err)
+	}
+}
+
+func TestScriptStepTimeout(t *testing.T) {
+	_, server := net.Pipe()
+
+	script := NewScript().Expect("never sent")
+	script.StepTimeout = 10 * time.Millisecond
+	if err := script.Run(server); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	n := NewNetwork()
+	if _, err := n.Dial(context.Background(), "nowhere:1"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected connection refused, got %v", err)
+	}
+
+	listener, _ := n.Listen(context.Background(), "closed:1")
+	listener.Close()
+	if _, err := n.Dial(context.Background(), "closed:1"); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected connection refused after close, got %v", err)
+	}
+}
+
+func TestResetFault(t *testing.T) {
+	n := NewNetwork()
+	n.ClientFaults = Faults{ResetAfter: 3}
+	StartServer(t, n, "sink:9", func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			_, err := io.Copy(io.Discard, conn)
+			return err
+		}
+	})
+
+	err := RunClient(context.Background(), n, network.ClientConfig{RemoteAddr: "sink:9"}, strings.NewReader("hello"), io.Discard)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected a reset, got %v", err)
+	}
+}
+
+func TestShortWritesAndChunkedReads(t *testing.T) {
+	a, b := net.Pipe()
+	writer := Faults{ShortWrite: 4}.Wrap(a)
+	reader := Faults{ReadChunk: 2}.Wrap(b)
+
+	go func() {
+		n, err := writer.Write([]byte("0123456789"))
+		if n != 4 || !errors.Is(err, io.ErrShortWrite) {
+			t.Errorf("expected a short write of 4 bytes, got %d, %v", n, err)
+		}
+		writer.Close()
+	}()
+
+	var reads []string
+	buf := make([]byte, 16)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if got := strings.Join(reads, "|"); got != "01|23" {
+		t.Errorf("expected two chunks of the short write, got %q", got)
+	}
+}
+
+func TestSlowReads(t *testing.T) {
+	a, b := net.Pipe()
+	reader := Faults{ReadDelay: 20 * time.Millisecond}.Wrap(b)
+	go a.Write([]byte("x"))
+
+	start := time.Now()
+	reader.Read(make([]byte, 1))
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the read to be delayed, took %v", elapsed)
+	}
+}
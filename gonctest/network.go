@@ -0,0 +1,133 @@
+// Package gonctest runs gonc clients and servers over an in-memory network,
+// with scripted peers and fault injection, so handlers can be tested
+// deterministically without binding real ports.
+package gonctest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// backlog is how many dialed connections wait for Accept before Dial blocks
+const backlog = 16
+
+// Addr is an address on a Network
+type Addr string
+
+func (a Addr) Network() string { return "memory" }
+func (a Addr) String() string  { return string(a) }
+
+// Network connects dialers to listeners by address, entirely in memory. It
+// implements both network.Dialer and network.ListenerFactory.
+type Network struct {
+	// ClientFaults and ServerFaults are injected into the two ends of every
+	// connection dialed from now on
+	ClientFaults Faults
+	ServerFaults Faults
+
+	mu        sync.Mutex
+	listeners map[string]*Listener
+	next      int
+}
+
+// NewNetwork returns an empty network
+func NewNetwork() *Network {
+	return &Network{listeners: make(map[string]*Listener)}
+}
+
+// Listen opens a listener on address, an empty address picks a free one
+func (n *Network) Listen(ctx context.Context, address string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if address == "" {
+		n.next++
+		address = fmt.Sprintf("listener-%d", n.next)
+	}
+	if _, taken := n.listeners[address]; taken {
+		return nil, &net.OpError{Op: "listen", Net: "memory", Addr: Addr(address), Err: syscall.EADDRINUSE}
+	}
+
+	l := &Listener{
+		network: n,
+		addr:    Addr(address),
+		conns:   make(chan net.Conn, backlog),
+		closed:  make(chan struct{}),
+	}
+	n.listeners[address] = l
+	return l, nil
+}
+
+// Dial connects to the listener on address
+func (n *Network) Dial(ctx context.Context, address string) (net.Conn, error) {
+	n.mu.Lock()
+	l := n.listeners[address]
+	n.next++
+	local := Addr(fmt.Sprintf("client-%d", n.next))
+	clientFaults, serverFaults := n.ClientFaults, n.ServerFaults
+	n.mu.Unlock()
+
+	refused := &net.OpError{Op: "dial", Net: "memory", Addr: Addr(address), Err: syscall.ECONNREFUSED}
+	if l == nil {
+		return nil, refused
+	}
+
+	client, server := net.Pipe()
+	clientConn := clientFaults.Wrap(&conn{Conn: client, local: local, remote: l.addr})
+	serverConn := serverFaults.Wrap(&conn{Conn: server, local: l.addr, remote: local})
+
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		return nil, refused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listener accepts the connections dialed to its address
+type Listener struct {
+	network   *Network
+	addr      Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting and frees the address
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+
+		l.network.mu.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is one end of an in-memory connection with meaningful addresses
+type conn struct {
+	net.Conn
+	local, remote Addr
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
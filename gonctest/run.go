@@ -0,0 +1,54 @@
+package gonctest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gppmad/gonc/network"
+	"github.com/gppmad/gonc/tcp_server"
+)
+
+// StartServer runs a TcpServer on address of n until the test ends. The
+// server reads an empty input and discards its output unless configure
+// changes them, configure may be nil.
+func StartServer(t testing.TB, n *Network, address string, configure func(*tcp_server.TcpServer)) *tcp_server.TcpServer {
+	t.Helper()
+
+	listener, err := n.Listen(context.Background(), address)
+	if err != nil {
+		t.Fatalf("cannot listen on %s: %v", address, err)
+	}
+
+	server := tcp_server.NewTcpServer(listener, strings.NewReader(""), io.Discard)
+	if configure != nil {
+		configure(server)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; !errors.Is(err, tcp_server.ErrServerClosed) {
+			t.Errorf("server stopped with an error: %v", err)
+		}
+	})
+	return server
+}
+
+// RunClient dials config.RemoteAddr on n and runs a network.Client session
+// reading input and writing to output until it ends
+func RunClient(ctx context.Context, n *Network, config network.ClientConfig, input io.Reader, output io.Writer) error {
+	config.Dialer = n
+	config.Input, config.Output = input, output
+
+	client, err := network.DialContext(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.StartContext(ctx)
+}
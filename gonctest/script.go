@@ -0,0 +1,130 @@
+package gonctest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gppmad/gonc/tcp_server"
+)
+
+// DefaultStepTimeout bounds every step of a script so a stuck peer fails the
+// test instead of hanging it
+const DefaultStepTimeout = 5 * time.Second
+
+type stepKind int
+
+const (
+	stepExpect stepKind = iota
+	stepExpectEOF
+	stepSend
+	stepSleep
+	stepHangup
+)
+
+type step struct {
+	kind  stepKind
+	data  []byte
+	delay time.Duration
+}
+
+// Script is a fake peer following a fixed conversation, built by chaining
+// steps:
+//
+//	gonctest.NewScript().Expect("HELLO\n").Send("WORLD\n").Hangup()
+type Script struct {
+	// StepTimeout bounds each step, DefaultStepTimeout when zero
+	StepTimeout time.Duration
+
+	steps []step
+}
+
+// NewScript returns an empty script
+func NewScript() *Script {
+	return &Script{}
+}
+
+// Expect reads exactly len(data) bytes and fails unless they match
+func (s *Script) Expect(data string) *Script {
+	s.steps = append(s.steps, step{kind: stepExpect, data: []byte(data)})
+	return s
+}
+
+// ExpectEOF fails unless the other side closes its end
+func (s *Script) ExpectEOF() *Script {
+	s.steps = append(s.steps, step{kind: stepExpectEOF})
+	return s
+}
+
+// Send writes data
+func (s *Script) Send(data string) *Script {
+	s.steps = append(s.steps, step{kind: stepSend, data: []byte(data)})
+	return s
+}
+
+// Sleep pauses the script
+func (s *Script) Sleep(d time.Duration) *Script {
+	s.steps = append(s.steps, step{kind: stepSleep, delay: d})
+	return s
+}
+
+// Hangup closes the connection, later steps are not allowed
+func (s *Script) Hangup() *Script {
+	s.steps = append(s.steps, step{kind: stepHangup})
+	return s
+}
+
+// Run plays the script on conn and reports the first step that failed
+func (s *Script) Run(conn net.Conn) error {
+	timeout := s.StepTimeout
+	if timeout == 0 {
+		timeout = DefaultStepTimeout
+	}
+
+	for i, st := range s.steps {
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		var err error
+		switch st.kind {
+		case stepExpect:
+			got := make([]byte, len(st.data))
+			var n int
+			n, err = io.ReadFull(conn, got)
+			if err == nil && !bytes.Equal(got, st.data) {
+				err = fmt.Errorf("expected %q, got %q", st.data, got)
+			} else if err != nil {
+				err = fmt.Errorf("expected %q, got %q: %w", st.data, got[:n], err)
+			}
+		case stepExpectEOF:
+			var extra []byte
+			extra, err = io.ReadAll(conn)
+			if err == nil && len(extra) > 0 {
+				err = fmt.Errorf("expected EOF, got %q", extra)
+			}
+		case stepSend:
+			_, err = conn.Write(st.data)
+		case stepSleep:
+			time.Sleep(st.delay)
+		case stepHangup:
+			err = conn.Close()
+			if i != len(s.steps)-1 {
+				return errors.New("steps after Hangup cannot run")
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("script step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Handler plays the script on every connection of a server
+func (s *Script) Handler() tcp_server.Handler {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		defer conn.Close()
+		return s.Run(conn)
+	}
+}
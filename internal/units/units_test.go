@@ -0,0 +1,20 @@
+package units
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[float64]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		1024:       "1.0 KiB",
+		1536:       "1.5 KiB",
+		5 << 20:    "5.0 MiB",
+		3 << 40:    "3.0 TiB",
+		4096 << 40: "4096.0 TiB",
+	}
+	for n, want := range tests {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%v) = %q, want %q", n, got, want)
+		}
+	}
+}
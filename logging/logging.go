@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// Formats accepted by New
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New creates a logger writing records at level or above to w, formatted
+// as text or JSON
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// LevelFromVerbosity maps the number of -v flags to a level: warnings and
+// errors by default, informational events with -v, everything with -vv
+func LevelFromVerbosity(verbosity int) slog.Level {
+	switch {
+	case verbosity >= 2:
+		return slog.LevelDebug
+	case verbosity == 1:
+		return slog.LevelInfo
+	}
+	return slog.LevelWarn
+}
+
+// Discard returns a logger dropping every record
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// OrDiscard returns logger, or a discarding logger when it is nil, so
+// components can be used without configuring logging
+func OrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return Discard()
+	}
+	return logger
+}
+
+// RemoteAddr is an attribute for the remote address of conn, only
+// resolved when a record is actually written
+func RemoteAddr(conn net.Conn) slog.Attr {
+	return slog.Any("remote", addrValuer(conn.RemoteAddr))
+}
+
+// LocalAddr is an attribute for the local address of conn
+func LocalAddr(conn net.Conn) slog.Attr {
+	return slog.Any("local", addrValuer(conn.LocalAddr))
+}
+
+type addrValuer func() net.Addr
+
+func (f addrValuer) LogValue() slog.Value {
+	if addr := f(); addr != nil {
+		return slog.StringValue(addr.String())
+	}
+	return slog.StringValue("")
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger, err := New(buf, slog.LevelInfo, FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("hidden")
+	logger.Info("connected", "remote", "127.0.0.1:80")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug record should be filtered, got %q", out)
+	}
+	if !strings.Contains(out, "msg=connected") || !strings.Contains(out, "remote=127.0.0.1:80") {
+		t.Errorf("unexpected text output %q", out)
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger, err := New(buf, slog.LevelDebug, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("accepted", "remote", "10.0.0.1:4000")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid JSON output %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "accepted" || record["remote"] != "10.0.0.1:4000" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New(new(bytes.Buffer), slog.LevelInfo, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLevelFromVerbosity(t *testing.T) {
+	tests := map[int]slog.Level{0: slog.LevelWarn, 1: slog.LevelInfo, 2: slog.LevelDebug, 3: slog.LevelDebug}
+	for verbosity, want := range tests {
+		if got := LevelFromVerbosity(verbosity); got != want {
+			t.Errorf("LevelFromVerbosity(%d) = %v, want %v", verbosity, got, want)
+		}
+	}
+}
+
+func TestOrDiscard(t *testing.T) {
+	logger := OrDiscard(nil)
+	if logger.Enabled(context.Background(), slog.LevelError) {
+		t.Error("the discarding logger should not be enabled")
+	}
+	logger.Error("dropped")
+}
@@ -1,50 +1,155 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/gppmad/gonc/acl"
+	"github.com/gppmad/gonc/compression"
+	"github.com/gppmad/gonc/logging"
 	"github.com/gppmad/gonc/network"
+	"github.com/gppmad/gonc/resolve"
+	"github.com/gppmad/gonc/shaping"
+	"github.com/gppmad/gonc/sockopt"
+	"github.com/gppmad/gonc/stats"
+	"github.com/gppmad/gonc/tcp_server"
+	"github.com/gppmad/gonc/telnet"
+	"github.com/gppmad/gonc/terminal"
+	"github.com/gppmad/gonc/udp"
 )
 
-func printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  Client mode (default): gonc [options] HOST:PORT")
-	fmt.Println("  Server mode: gonc -l [options] PORT")
-	fmt.Println("\nOptions:")
-	fmt.Println("  -tls          Use TLS for the connection")
-	fmt.Println("  -l            Listen mode (server)")
-	fmt.Println("  -h            Show this help message")
-	fmt.Println("\nExamples:")
-	fmt.Println("  gonc example.com:8080     Connect to example.com on port 8080")
-	fmt.Println("  gonc -tls example.com:443 Connect to example.com on port 443 using TLS")
-	fmt.Println("  gonc -l 8080              Listen on port 8080")
-	fmt.Println("  gonc -l -tls 443          Listen on port 443 using TLS")
-}
-
-func validateArgs(serverMode bool, args []string) bool {
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  Client mode (default): gonc [options] HOST:PORT")
+	fmt.Fprintln(w, "  Server mode: gonc -l [options] PORT")
+	fmt.Fprintln(w, "  Unix sockets: gonc -U [-l] [options] PATH")
+	fmt.Fprintln(w, "\nOptions:")
+	fmt.Fprintln(w, "  -tls          Use TLS for the connection")
+	fmt.Fprintln(w, "  -l            Listen mode (server)")
+	fmt.Fprintln(w, "  -u            Use UDP instead of TCP")
+	fmt.Fprintln(w, "  -U            Use a Unix domain socket, the argument is its path")
+	fmt.Fprintln(w, "  -proxy addr   Connect through the SOCKS5 proxy at addr (client mode)")
+	fmt.Fprintln(w, "  -s addr       Connect from this local IP address (client mode)")
+	fmt.Fprintln(w, "  -p port       Connect from this local port (client mode)")
+	fmt.Fprintln(w, "  -bind list    Listen on these comma separated addresses or names (server mode)")
+	fmt.Fprintln(w, "  -bind-device  Listen only on this network device, e.g. eth0 (Linux, server mode)")
+	fmt.Fprintln(w, "  -accept-proxy Expect a PROXY protocol v1/v2 header on every connection (server mode)")
+	fmt.Fprintln(w, "  -proxy-from list  Only accept PROXY headers from these comma separated CIDRs")
+	fmt.Fprintln(w, "  -send-proxy v Send a PROXY protocol header, v1 or v2, after connecting (client mode)")
+	fmt.Fprintln(w, "  -send-proxy-src addr  Client address in the PROXY header, IP:PORT (default the local address)")
+	fmt.Fprintln(w, "  -n            Numeric only, never look up host names (nor client names in listen mode)")
+	fmt.Fprintln(w, "  -resolve h:p:addr  Connect to addr for host h and port p, repeatable (client mode)")
+	fmt.Fprintln(w, "  -resolver addr  Query the DNS server at addr instead of the system one (client mode)")
+	fmt.Fprintln(w, "  -attempt-delay d  Head start of each address of a host before the next is tried (default 250ms, 0 tries one at a time)")
+	fmt.Fprintln(w, "  -all          Connect to every address of the host in turn and report which are up (client mode)")
+	fmt.Fprintln(w, "  -keepalive d  Send TCP keepalive probes after d of silence")
+	fmt.Fprintln(w, "  -keepalive-interval d, -keepalive-count n  Time between probes, probes before giving up")
+	fmt.Fprintln(w, "  -nodelay      Disable Nagle's algorithm (default true, -nodelay=false enables it)")
+	fmt.Fprintln(w, "  -sndbuf n     Socket send buffer size in bytes")
+	fmt.Fprintln(w, "  -rcvbuf n     Socket receive buffer size in bytes")
+	fmt.Fprintln(w, "  -linger d     On close wait up to d for unsent data")
+	fmt.Fprintln(w, "  -rst          Close connections with a RST instead of a FIN")
+	fmt.Fprintln(w, "  -user-timeout d  Drop the connection when sent data stays unacknowledged for d (Linux)")
+	fmt.Fprintln(w, "  -tos n, -dscp n  Set the IP TOS byte, or its DSCP bits (0-63)")
+	fmt.Fprintln(w, "  -mcast addr   Join the multicast group GROUP:PORT with -l, send stdin to it otherwise")
+	fmt.Fprintln(w, "  -iface name   Network interface for -mcast, e.g. eth0")
+	fmt.Fprintln(w, "  -ttl n        Hop limit of multicast datagrams (default 1)")
+	fmt.Fprintln(w, "  -mcast-loop   Deliver our multicast datagrams to listeners on this host too")
+	fmt.Fprintln(w, "  -broadcast    Send UDP datagrams to a broadcast address and print replies from anyone (with -u)")
+	fmt.Fprintln(w, "  -frame mode   Cut stdin into UDP datagrams per read (default), line, length (2 byte prefix) or N bytes")
+	fmt.Fprintln(w, "  -format fmt   Print received UDP datagrams raw (default), as hex or one line each with sender and size")
+	fmt.Fprintln(w, "  -max-datagram n  Truncate received UDP datagrams longer than n bytes, with a warning")
+	fmt.Fprintln(w, "  -cert file    Certificate for -tls in listen mode (PEM)")
+	fmt.Fprintln(w, "  -key file     Private key for -tls in listen mode (PEM)")
+	fmt.Fprintln(w, "  -t            Telnet mode: answer option negotiation and strip it from the output")
+	fmt.Fprintln(w, "  -telnet-echo  Let the server echo input (telnet ECHO option)")
+	fmt.Fprintln(w, "  -telnet-term  Terminal type reported to the server (e.g. xterm)")
+	fmt.Fprintln(w, "  -telnet-size  Window size reported through NAWS (e.g. 80x24)")
+	fmt.Fprintln(w, "  -raw          Put the local terminal in raw mode (interactive remote shells)")
+	fmt.Fprintln(w, "  -send path    Send a file or directory with its checksum (client mode)")
+	fmt.Fprintln(w, "  -recv dir     Receive files sent with -send into dir, never overwriting (server mode)")
+	fmt.Fprintln(w, "  -compress alg Compress the stream with gzip, zlib or flate (both peers)")
+	fmt.Fprintln(w, "  -rate n       Limit each direction to n bytes/s (suffixes K, M, G)")
+	fmt.Fprintln(w, "  -latency d    Delay the data in each direction by d (e.g. 100ms)")
+	fmt.Fprintln(w, "  -jitter d     Vary the latency randomly by up to d")
+	fmt.Fprintln(w, "  -chunk n      Move data in chunks of at most n bytes")
+	fmt.Fprintln(w, "  -stats fmt    Print connection statistics on exit as text or json")
+	fmt.Fprintln(w, "  -allow list   Only accept clients from these comma separated CIDRs (server mode)")
+	fmt.Fprintln(w, "  -deny list    Reject clients from these comma separated CIDRs (server mode)")
+	fmt.Fprintln(w, "  -allow-file   Read allowed CIDRs from a file, one per line")
+	fmt.Fprintln(w, "  -deny-file    Read denied CIDRs from a file, one per line")
+	fmt.Fprintln(w, "  -max-conns n  Handle at most n connections at once (server mode)")
+	fmt.Fprintln(w, "  -conn-policy  What to do over -max-conns: reject (default) or queue")
+	fmt.Fprintln(w, "  -ip-rate n/d  Accept at most n connections per source IP every d (e.g. 10/1m)")
+	fmt.Fprintln(w, "  -drain d      On shutdown wait up to d for active connections (default 10s)")
+	fmt.Fprintln(w, "  -v, -vv       Verbose logging to stderr (-vv includes debug events)")
+	fmt.Fprintln(w, "  -log-format   Log format: text (default) or json")
+	fmt.Fprintln(w, "  -h            Show this help message")
+	fmt.Fprintln(w, "\nExamples:")
+	fmt.Fprintln(w, "  gonc example.com:8080     Connect to example.com on port 8080")
+	fmt.Fprintln(w, "  gonc -tls example.com:443 Connect to example.com on port 443 using TLS")
+	fmt.Fprintln(w, "  gonc -l 8080              Listen on port 8080")
+	fmt.Fprintln(w, "  gonc -l -tls -cert c.pem -key k.pem 443  Listen on port 443 using TLS")
+	fmt.Fprintln(w, "  gonc -l -bind 127.0.0.1,::1 8080  Listen on loopback only")
+	fmt.Fprintln(w, "  gonc -u -l 5353           Listen for UDP datagrams on port 5353")
+	fmt.Fprintln(w, "  gonc -l -mcast 239.255.255.250:1900 -iface eth0  Watch SSDP announcements")
+	fmt.Fprintln(w, "  gonc -u -broadcast 192.168.1.255:9  Broadcast stdin on the local network")
+	fmt.Fprintln(w, "  gonc -u -frame line -format line host:514 < events  One syslog datagram per line")
+	fmt.Fprintln(w, "  gonc -u -frame 1472 host:9000 < blob  Send MTU sized datagrams")
+	fmt.Fprintln(w, "  gonc -U /tmp/app.sock     Connect to a Unix domain socket")
+	fmt.Fprintln(w, "  gonc -s 10.0.0.5 -p 4000 host:80  Connect from 10.0.0.5 port 4000")
+	fmt.Fprintln(w, "  gonc -proxy 127.0.0.1:1080 -tls host:443  TLS through a SOCKS5 proxy")
+	fmt.Fprintln(w, "  gonc -l -allow 10.0.0.0/8 8080  Only accept clients from 10.0.0.0/8")
+	fmt.Fprintln(w, "  gonc -all example.com:443     Check every address of example.com")
+	fmt.Fprintln(w, "  gonc -tls -resolve example.com:443:10.0.0.9 example.com:443  Test a new server before the DNS switch")
+	fmt.Fprintln(w, "  gonc -send-proxy v2 -send-proxy-src 198.51.100.7:4000 backend:80  Act as a load balancer")
+	fmt.Fprintln(w, "  gonc -keepalive 30s -dscp 46 host:5060  Keepalive probes and expedited forwarding")
+	fmt.Fprintln(w, "  gonc -t router.lan:23     Connect to a telnet service")
+	fmt.Fprintln(w, "  gonc -raw -t host:23      Interactive telnet session, window size forwarded")
+	fmt.Fprintln(w, "  gonc -send build/ host:9000  Send the build directory")
+	fmt.Fprintln(w, "  gonc -l -recv /tmp 9000   Receive transfers into /tmp")
+	fmt.Fprintln(w, "  gonc -compress gzip host:9000 < app.log  Send a log compressed")
+	fmt.Fprintln(w, "  gonc -rate 64K -latency 200ms host:80    Simulate a slow link")
+	fmt.Fprintln(w, "  gonc -vv -log-format json host:80        Log connection events as JSON")
+}
+
+func validateArgs(serverMode, unixSocket bool, args []string) bool {
 	if len(args) != 1 {
-		if serverMode {
-			fmt.Println("Error: Server mode requires a PORT argument")
+		if unixSocket {
+			fmt.Fprintln(os.Stderr, "Error: Unix socket mode requires a PATH argument")
+		} else if serverMode {
+			fmt.Fprintln(os.Stderr, "Error: Server mode requires a PORT argument")
 		} else {
-			fmt.Println("Error: Client mode requires a HOST:PORT argument")
+			fmt.Fprintln(os.Stderr, "Error: Client mode requires a HOST:PORT argument")
 		}
 		return false
 	}
 
-	if serverMode {
+	if unixSocket {
+		// Any non empty path names a socket
+		if args[0] == "" {
+			fmt.Fprintln(os.Stderr, "Error: PATH cannot be empty")
+			return false
+		}
+	} else if serverMode {
 		// Server mode: validate port only
 		port := args[0]
 
 		// Check if port is numeric
 		for _, c := range port {
 			if c < '0' || c > '9' {
-				fmt.Println("Error: PORT must be numeric")
+				fmt.Fprintln(os.Stderr, "Error: PORT must be numeric")
 				return false
 			}
 		}
@@ -53,32 +158,30 @@ func validateArgs(serverMode bool, args []string) bool {
 		portNum := 0
 		fmt.Sscanf(port, "%d", &portNum)
 		if portNum <= 0 || portNum > 65535 {
-			fmt.Println("Error: PORT must be between 1 and 65535")
+			fmt.Fprintln(os.Stderr, "Error: PORT must be between 1 and 65535")
 			return false
 		}
 	} else {
 		// Client mode: validate host:port format
 		remoteAddr := args[0]
 
-		// Check for presence of ":" separator
-		parts := strings.Split(remoteAddr, ":")
-		if len(parts) != 2 {
-			fmt.Println("Error: Client mode requires HOST:PORT format")
+		// Split on the last ":", IPv6 addresses go in brackets
+		host, port, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Client mode requires HOST:PORT format")
 			return false
 		}
 
-		host, port := parts[0], parts[1]
-
 		// Validate host
 		if host == "" {
-			fmt.Println("Error: HOST cannot be empty")
+			fmt.Fprintln(os.Stderr, "Error: HOST cannot be empty")
 			return false
 		}
 
 		// Validate port (must be numeric)
 		for _, c := range port {
 			if c < '0' || c > '9' {
-				fmt.Println("Error: PORT must be numeric")
+				fmt.Fprintln(os.Stderr, "Error: PORT must be numeric")
 				return false
 			}
 		}
@@ -87,7 +190,7 @@ func validateArgs(serverMode bool, args []string) bool {
 		portNum := 0
 		fmt.Sscanf(port, "%d", &portNum)
 		if portNum <= 0 || portNum > 65535 {
-			fmt.Println("Error: PORT must be between 1 and 65535")
+			fmt.Fprintln(os.Stderr, "Error: PORT must be between 1 and 65535")
 			return false
 		}
 	}
@@ -95,23 +198,255 @@ func validateArgs(serverMode bool, args []string) bool {
 	return true
 }
 
-func runClient(host, port string, requireTLS bool) error {
-	address := fmt.Sprintf("%s:%s", host, port)
+// parseWindowSize parses a WIDTHxHEIGHT window size
+func parseWindowSize(size string) (uint16, uint16, error) {
+	var width, height uint16
+	if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil || width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("invalid window size %q, expected WIDTHxHEIGHT", size)
+	}
+	return width, height, nil
+}
+
+// enterRawMode puts stdin in raw mode and returns the function restoring it.
+// The returned function can be called more than once.
+func enterRawMode() (func(), error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("raw mode requires stdin to be a terminal")
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error entering raw mode: %w", err)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { terminal.Restore(fd, state) })
+	}, nil
+}
+
+// exitOnSignal runs cleanup and exits when the process is interrupted or
+// killed, until the returned stop function is called. In raw mode Ctrl-C no
+// longer raises SIGINT, but kill and hangups still do.
+func exitOnSignal(logger *slog.Logger, cleanup func(os.Signal)) (stop func()) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+
+	go func() {
+		sig, ok := <-signalChan
+		if !ok {
+			return
+		}
+		cleanup(sig)
+		logger.Info("received signal", "signal", sig.String())
+		os.Exit(1)
+	}()
+
+	return func() {
+		signal.Stop(signalChan)
+		close(signalChan)
+	}
+}
+
+// printStats writes a statistics summary to stderr
+func printStats(logger *slog.Logger, s *stats.Stats, format string) {
+	if err := s.Summary().Write(os.Stderr, format); err != nil {
+		logger.Error("error printing statistics", "error", err)
+	}
+}
+
+// buildACL combines the entries given on the command line and in files.
+// It returns nil when no entry was given.
+func buildACL(allow, deny, allowFile, denyFile string) (*acl.List, error) {
+	allowed, denied := acl.Split(allow), acl.Split(deny)
+
+	if allowFile != "" {
+		entries, err := acl.ReadFile(allowFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading allow file: %w", err)
+		}
+		allowed = append(allowed, entries...)
+	}
+	if denyFile != "" {
+		entries, err := acl.ReadFile(denyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading deny file: %w", err)
+		}
+		denied = append(denied, entries...)
+	}
+
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	return acl.New(allowed, denied)
+}
+
+// fatal logs err and exits with a failure status
+func fatal(logger *slog.Logger, err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
+
+// validateSource checks the source address and port of client connections
+func validateSource(serverMode, unixSocket bool, sourceAddr string, sourcePort int) bool {
+	if sourceAddr == "" && sourcePort == 0 {
+		return true
+	}
+
+	if serverMode {
+		fmt.Fprintln(os.Stderr, "Error: -s and -p only apply to client mode")
+		return false
+	}
+	if unixSocket {
+		fmt.Fprintln(os.Stderr, "Error: -s and -p cannot be used with Unix sockets")
+		return false
+	}
+	if sourceAddr != "" && net.ParseIP(sourceAddr) == nil {
+		fmt.Fprintln(os.Stderr, "Error: source address must be an IP address")
+		return false
+	}
+	if sourcePort < 0 || sourcePort > 65535 {
+		fmt.Fprintln(os.Stderr, "Error: source port must be between 1 and 65535")
+		return false
+	}
+
+	return true
+}
+
+// validateMulticast checks the group of -mcast and the interface of -iface
+// before anything is opened
+func validateMulticast(group, iface string) error {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return fmt.Errorf("invalid -mcast group: %w", err)
+	}
+	if !addr.IP.IsMulticast() {
+		return fmt.Errorf("-mcast %s is not a multicast address", addr.IP)
+	}
+	if iface == "" {
+		return nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("invalid -iface: %w", err)
+	}
+	if ifi.Flags&net.FlagMulticast == 0 {
+		return fmt.Errorf("interface %s does not support multicast", iface)
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", iface)
+	}
+	return nil
+}
+
+// runDatagrams joins the multicast group target and prints what it
+// receives, or sends the datagrams of input to target, a group or a
+// broadcast address, and prints the replies
+func runDatagrams(logger *slog.Logger, target string, join bool, opts udp.Options, input *udp.FrameReader, printer *udp.Printer) error {
+	addr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	config := network.ClientConfig{
-		RemoteAddr: address,
-		RequireTLS: requireTLS,
+	if join {
+		conn, err := udp.Join(addr, opts.Interface)
+		if err != nil {
+			return err
+		}
+		name := "default"
+		if opts.Interface != nil {
+			name = opts.Interface.Name
+		}
+		logger.Info("joined multicast group", "group", addr.String(), "interface", name)
+		context.AfterFunc(ctx, func() { conn.Close() })
+		return udp.Receive(conn, printer)
+	}
+
+	conn, err := udp.Dial(ctx, addr, opts)
+	if err != nil {
+		return err
 	}
+	logger.Info("sending datagrams", "address", addr.String(), logging.LocalAddr(conn))
+	return udp.Exchange(ctx, conn, addr, input, printer)
+}
+
+// parseProxyVersion parses the PROXY protocol version of -send-proxy
+func parseProxyVersion(s string) (int, error) {
+	switch strings.TrimPrefix(s, "v") {
+	case "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("invalid -send-proxy version %q, expected v1 or v2", s)
+}
+
+// probeAddresses connects to every address of the remote host and reports
+// which ones are up, it fails when none is
+func probeAddresses(config network.ClientConfig) error {
+	results, err := network.ProbeAll(context.Background(), config)
+	if err != nil {
+		return err
+	}
+
+	up := 0
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s down: %v\n", r.Address, r.Err)
+			continue
+		}
+		up++
+		fmt.Fprintf(os.Stderr, "%s up (%v)\n", r.Address, r.Duration.Round(time.Millisecond))
+	}
+	if up == 0 {
+		return fmt.Errorf("no address of %s is up", config.RemoteAddr)
+	}
+	return nil
+}
+
+func runClient(config network.ClientConfig, raw bool, statsFormat string) error {
+	logger := logging.OrDiscard(config.Logger)
 
 	client, err := network.NewClient(config)
 	if err != nil {
 		return fmt.Errorf("error creating client: %w", err)
 	}
 
-	if requireTLS {
-		fmt.Println("Connected to a TLS Server")
-	} else {
-		fmt.Println("Connected to a TCP Server")
+	restore := func() {}
+	if raw {
+		restore, err = enterRawMode()
+		if err != nil {
+			client.Close()
+			return err
+		}
+		defer restore()
+
+		// Keep the remote side informed of the window size (telnet NAWS)
+		stop := terminal.NotifyResize(int(os.Stdin.Fd()), func(size terminal.WindowSize) {
+			network.ResizeWindow(client, size.Width, size.Height)
+		})
+		defer stop()
+	}
+
+	if config.Stats != nil {
+		defer printStats(logger, config.Stats, statsFormat)
+	}
+
+	// Leave the terminal and the statistics in order when killed
+	if raw || config.Stats != nil {
+		stop := exitOnSignal(logger, func(sig os.Signal) {
+			restore()
+			if config.Stats != nil {
+				config.Stats.Finish(fmt.Errorf("interrupted by %v", sig))
+				printStats(logger, config.Stats, statsFormat)
+			}
+		})
+		defer stop()
 	}
 
 	if err := client.Start(); err != nil {
@@ -125,34 +460,49 @@ func runClient(host, port string, requireTLS bool) error {
 	return nil
 }
 
-func runServer(port string, requireTLS bool) error {
-	fmt.Printf("Starting server on port %s (TLS: %v)\n", port, requireTLS)
+func runServer(config network.ServerConfig) error {
+	logger := logging.OrDiscard(config.Logger)
+	logger.Info("starting server", "port", config.Port, "address", config.Address, "tls", config.RequireTLS)
 
-	config := network.ServerConfig{IP: "", Port: port, RequireTLS: requireTLS}
 	server, err := network.NewServer(config)
 	if err != nil {
 		return fmt.Errorf("error creating server: %w ", err)
 	}
 
-	// Handle OS signal to gracefully shut down the server
-	signalChan := make(chan os.Signal, 1)
+	// The first signal drains active connections, a second one closes them right away
+	signalChan := make(chan os.Signal, 2)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
 
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
+
 		sig := <-signalChan
-		fmt.Printf("Received signal: %v\n", sig)
-		if err := server.Close(); err != nil {
-			log.Printf("Error closing server: %v", err)
+		logger.Info("received signal, draining connections", "signal", sig.String(), "timeout", config.DrainTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+		defer cancel()
+		go func() {
+			select {
+			case sig := <-signalChan:
+				logger.Warn("received second signal, closing connections", "signal", sig.String())
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := server.Shutdown(ctx); err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			logger.Error("error closing server", "error", err)
 		}
-		fmt.Println("Server shut down gracefully")
-		os.Exit(0)
+		logger.Info("server shut down gracefully")
 	}()
 
-	// Start the server
-	fmt.Printf("Server started on %s \n", port)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Server error: %v", err)
+	// Start the server, it returns once the listener is closed
+	if err := server.Start(); err != nil && !errors.Is(err, tcp_server.ErrServerClosed) {
+		return fmt.Errorf("server error: %w", err)
 	}
+	<-shutdownDone
 
 	return nil
 }
@@ -162,37 +512,347 @@ func main() {
 	// Get the flags and parse them
 	requireTLS := flag.Bool("tls", false, "Use TLS for the connection")
 	serverMode := flag.Bool("l", false, "Listen mode - start server instead of client")
+	udpMode := flag.Bool("u", false, "Use UDP instead of TCP")
+	unixSocket := flag.Bool("U", false, "Use a Unix domain socket")
+	proxy := flag.String("proxy", "", "SOCKS5 proxy address for client connections")
+	sourceAddr := flag.String("s", "", "Local source IP address for client connections")
+	sourcePort := flag.Int("p", 0, "Local source port for client connections")
+	bind := flag.String("bind", "", "Comma separated addresses to listen on")
+	bindDevice := flag.String("bind-device", "", "Network device to listen on (Linux)")
+	acceptProxy := flag.Bool("accept-proxy", false, "Expect a PROXY protocol header on every connection")
+	proxyFrom := flag.String("proxy-from", "", "Comma separated CIDRs allowed to send PROXY headers")
+	sendProxy := flag.String("send-proxy", "", "PROXY protocol header version to send: v1 or v2")
+	sendProxySrc := flag.String("send-proxy-src", "", "Client address in the PROXY header, IP:PORT")
+	numeric := flag.Bool("n", false, "Numeric only, no DNS lookups")
+	resolver := &resolve.Resolver{}
+	flag.Func("resolve", "Use this address for a host and port, HOST:PORT:ADDR (repeatable)", resolver.AddOverride)
+	dnsServer := flag.String("resolver", "", "DNS server address to query")
+	attemptDelay := flag.Duration("attempt-delay", network.DefaultAttemptDelay, "Head start of each address before the next is tried")
+	probeAll := flag.Bool("all", false, "Connect to every resolved address in turn")
+	keepAlive := flag.Duration("keepalive", 0, "Idle time before TCP keepalive probes")
+	keepAliveInterval := flag.Duration("keepalive-interval", 0, "Time between TCP keepalive probes")
+	keepAliveCount := flag.Int("keepalive-count", 0, "Unanswered keepalive probes before dropping the connection")
+	noDelay := flag.Bool("nodelay", true, "Disable Nagle's algorithm (TCP_NODELAY)")
+	sendBuffer := flag.Int("sndbuf", 0, "Socket send buffer size in bytes")
+	recvBuffer := flag.Int("rcvbuf", 0, "Socket receive buffer size in bytes")
+	linger := flag.Duration("linger", 0, "How long close waits for unsent data")
+	resetOnClose := flag.Bool("rst", false, "Close connections with a RST")
+	userTimeout := flag.Duration("user-timeout", 0, "TCP_USER_TIMEOUT for unacknowledged data (Linux)")
+	tos := flag.Int("tos", 0, "IP type of service byte")
+	dscp := flag.Int("dscp", 0, "IP DSCP value (0-63)")
+	mcast := flag.String("mcast", "", "Multicast group to join or send to, GROUP:PORT")
+	iface := flag.String("iface", "", "Network interface for multicast")
+	ttl := flag.Int("ttl", 0, "Hop limit of multicast datagrams")
+	mcastLoop := flag.Bool("mcast-loop", false, "Deliver multicast datagrams to listeners on this host")
+	broadcast := flag.Bool("broadcast", false, "Allow sending UDP datagrams to broadcast addresses")
+	frame := flag.String("frame", "", "How stdin is cut into UDP datagrams: read, line, length or a size in bytes")
+	format := flag.String("format", "", "How received UDP datagrams are printed: raw, hex or line")
+	maxDatagram := flag.Int("max-datagram", 0, "Largest received UDP datagram, longer ones are truncated")
+	certFile := flag.String("cert", "", "Certificate file for TLS listen mode")
+	keyFile := flag.String("key", "", "Private key file for TLS listen mode")
+	telnetMode := flag.Bool("t", false, "Telnet mode - handle option negotiation")
+	telnetEcho := flag.Bool("telnet-echo", false, "Accept the telnet ECHO option")
+	telnetTerm := flag.String("telnet-term", "", "Terminal type reported in telnet mode")
+	telnetSize := flag.String("telnet-size", "", "Window size reported in telnet mode (WIDTHxHEIGHT)")
+	rawMode := flag.Bool("raw", false, "Put the local terminal in raw mode")
+	sendPath := flag.String("send", "", "File or directory to send")
+	recvDir := flag.String("recv", "", "Directory where received files are stored")
+	compress := flag.String("compress", "", "Compression algorithm: gzip, zlib or flate")
+	rate := flag.String("rate", "", "Bandwidth limit per direction in bytes/s (K, M, G suffixes)")
+	latency := flag.Duration("latency", 0, "Latency added to the data in each direction")
+	jitter := flag.Duration("jitter", 0, "Random variation of the latency")
+	chunk := flag.Int("chunk", 0, "Largest chunk of data moved at once")
+	statsFormat := flag.String("stats", "", "Print connection statistics on exit: text or json")
+	allow := flag.String("allow", "", "Comma separated CIDRs allowed to connect")
+	deny := flag.String("deny", "", "Comma separated CIDRs denied from connecting")
+	allowFile := flag.String("allow-file", "", "File with CIDRs allowed to connect")
+	denyFile := flag.String("deny-file", "", "File with CIDRs denied from connecting")
+	maxConns := flag.Int("max-conns", 0, "Maximum concurrent connections in listen mode")
+	connPolicy := flag.String("conn-policy", "reject", "Policy over -max-conns: reject or queue")
+	ipRate := flag.String("ip-rate", "", "Per source IP connection rate limit, COUNT/DURATION")
+	drain := flag.Duration("drain", 10*time.Second, "How long to wait for active connections on shutdown")
+	verbose := flag.Bool("v", false, "Verbose logging")
+	veryVerbose := flag.Bool("vv", false, "Debug logging")
+	logFormat := flag.String("log-format", logging.FormatText, "Log format: text or json")
 	helpFlag := flag.Bool("h", false, "Show help")
 
 	flag.Parse()
 
 	// Show help if requested or if no arguments provided
 	if *helpFlag {
-		printUsage()
+		printUsage(os.Stdout)
 		os.Exit(0) // Exit with success code when showing help
 	}
 
+	// Logs go to stderr so they never mix with the data on stdout
+	verbosity := 0
+	if *verbose {
+		verbosity = 1
+	}
+	if *veryVerbose {
+		verbosity = 2
+	}
+	logger, err := logging.New(os.Stderr, logging.LevelFromVerbosity(verbosity), *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
 	// Get the args
 	args := flag.Args()
 
 	// Validate arguments based on mode
-	if !validateArgs(*serverMode, args) {
-		printUsage()
+	if *mcast != "" {
+		if len(args) != 0 {
+			fmt.Fprintln(os.Stderr, "Error: -mcast takes the group instead of an argument")
+			printUsage(os.Stderr)
+			os.Exit(1)
+		}
+	} else if !validateArgs(*serverMode, *unixSocket, args) || !validateSource(*serverMode, *unixSocket, *sourceAddr, *sourcePort) {
+		printUsage(os.Stderr)
 		os.Exit(1)
 	}
 
+	switch {
+	case *udpMode && *unixSocket:
+		fatal(logger, errors.New("-u and -U cannot be used together"))
+	case *udpMode && *requireTLS:
+		fatal(logger, errors.New("TLS is not available over UDP"))
+	case *proxy != "" && (*serverMode || *udpMode || *unixSocket):
+		fatal(logger, errors.New("-proxy only works for TCP client connections"))
+	case (*bind != "" || *bindDevice != "") && (!*serverMode || *unixSocket):
+		fatal(logger, errors.New("-bind and -bind-device only work in TCP or UDP listen mode"))
+	case *probeAll && (*serverMode || *udpMode || *unixSocket || *proxy != ""):
+		fatal(logger, errors.New("-all only works for direct TCP client connections"))
+	case (len(resolver.Overrides) > 0 || *dnsServer != "") && (*serverMode || *unixSocket || *proxy != ""):
+		fatal(logger, errors.New("-resolve and -resolver only work for direct client connections"))
+	case *mcast != "" && (*unixSocket || *requireTLS || *proxy != "" || *broadcast):
+		fatal(logger, errors.New("-mcast cannot be used with -U, -tls, -proxy or -broadcast"))
+	case (*iface != "" || *ttl != 0 || *mcastLoop) && *mcast == "":
+		fatal(logger, errors.New("-iface, -ttl and -mcast-loop need -mcast"))
+	case (*mcast != "" || *broadcast) && (*sourceAddr != "" || *sourcePort != 0):
+		fatal(logger, errors.New("-s and -p cannot be used with -mcast or -broadcast"))
+	case *ttl < 0 || *ttl > 255:
+		fatal(logger, fmt.Errorf("invalid -ttl %d, expected 1-255", *ttl))
+	case *broadcast && (!*udpMode || *serverMode):
+		fatal(logger, errors.New("-broadcast only works for UDP client mode (-u)"))
+	case *acceptProxy && (!*serverMode || *udpMode):
+		fatal(logger, errors.New("-accept-proxy only works in TCP or Unix listen mode"))
+	case *proxyFrom != "" && !*acceptProxy:
+		fatal(logger, errors.New("-proxy-from needs -accept-proxy"))
+	case (*sendProxy != "" || *sendProxySrc != "") && (*serverMode || *udpMode || *unixSocket || *proxy != ""):
+		fatal(logger, errors.New("-send-proxy only works for direct TCP client connections"))
+	case *sendProxySrc != "" && *sendProxy == "":
+		fatal(logger, errors.New("-send-proxy-src needs -send-proxy"))
+	case *frame != "" && !((*udpMode || *mcast != "") && !*serverMode):
+		fatal(logger, errors.New("-frame only works for UDP client mode (-u or -mcast without -l)"))
+	case (*format != "" || *maxDatagram != 0) && !*udpMode && *mcast == "":
+		fatal(logger, errors.New("-format and -max-datagram only work in UDP mode (-u or -mcast)"))
+	case *maxDatagram < 0 || *maxDatagram > udp.MaxDatagram:
+		fatal(logger, fmt.Errorf("invalid -max-datagram %d, expected 1-%d", *maxDatagram, udp.MaxDatagram))
+	case *tos != 0 && *dscp != 0:
+		fatal(logger, errors.New("-tos and -dscp cannot be used together"))
+	case *tos < 0 || *tos > 255:
+		fatal(logger, fmt.Errorf("invalid -tos %d, expected 0-255", *tos))
+	case *dscp < 0 || *dscp > 63:
+		fatal(logger, fmt.Errorf("invalid -dscp %d, expected 0-63", *dscp))
+	}
+	if *mcast != "" {
+		if err := validateMulticast(*mcast, *iface); err != nil {
+			fatal(logger, err)
+		}
+	}
+
 	// Run in appropriate mode
-	var err error
+	var algo compression.Algorithm
+	if *compress != "" {
+		if algo, err = compression.Parse(*compress); err != nil {
+			fatal(logger, err)
+		}
+	}
+
+	if *statsFormat != "" && *statsFormat != "text" && *statsFormat != "json" {
+		fatal(logger, fmt.Errorf("invalid -stats format %q, expected text or json", *statsFormat))
+	}
+
+	shape := shaping.Options{Latency: *latency, Jitter: *jitter, ChunkSize: *chunk}
+	if *rate != "" {
+		if shape.Rate, err = shaping.ParseRate(*rate); err != nil {
+			fatal(logger, err)
+		}
+	}
+
+	sockOpts := sockopt.Options{
+		KeepAlive:         *keepAlive,
+		KeepAliveInterval: *keepAliveInterval,
+		KeepAliveCount:    *keepAliveCount,
+		Nagle:             !*noDelay,
+		SendBuffer:        *sendBuffer,
+		ReceiveBuffer:     *recvBuffer,
+		Linger:            *linger,
+		ResetOnClose:      *resetOnClose,
+		UserTimeout:       *userTimeout,
+		TOS:               *tos | *dscp<<2,
+	}
+
+	framing, frameSize, err := udp.ParseFraming(*frame)
+	if err != nil {
+		fatal(logger, err)
+	}
+	printer := &udp.Printer{Output: os.Stdout, MaxSize: *maxDatagram, Logger: logger}
+	if printer.Format, err = udp.ParseFormat(*format); err != nil {
+		fatal(logger, err)
+	}
+	frames := udp.NewFrameReader(os.Stdin, framing, frameSize)
+	frames.Logger = logger
+
+	if *mcast != "" || *broadcast {
+		target := *mcast
+		if target == "" {
+			target = args[0]
+		}
+		opts := udp.Options{TTL: *ttl, Loopback: *mcastLoop, Broadcast: *broadcast}
+		if *iface != "" {
+			if opts.Interface, err = net.InterfaceByName(*iface); err != nil {
+				fatal(logger, err)
+			}
+		}
+		// Replies may come from anyone, their senders go to stderr
+		printer.Senders = os.Stderr
+		if err := runDatagrams(logger, target, *mcast != "" && *serverMode, opts, frames, printer); err != nil {
+			fatal(logger, err)
+		}
+		return
+	}
+
 	if *serverMode {
-		err = runServer(args[0], *requireTLS)
+		config := network.ServerConfig{
+			RequireTLS:    *requireTLS,
+			CertFile:      *certFile,
+			KeyFile:       *keyFile,
+			BindDevice:    *bindDevice,
+			SocketOptions: sockOpts,
+			ProxyProtocol: *acceptProxy,
+			ReverseDNS:    !*numeric,
+			RecvDir:       *recvDir,
+			Compression:   algo,
+			Shaping:       shape,
+			Logger:        logger,
+		}
+		config.OnListen = func(addr net.Addr) {
+			fmt.Fprintf(os.Stderr, "Listening on %s\n", addr)
+		}
+		config.OnAccept = func(info *tcp_server.ConnInfo) {
+			fmt.Fprintf(os.Stderr, "Connection from %s\n", info)
+		}
+		if *statsFormat != "" {
+			config.OnStats = func(s *stats.Stats) { printStats(logger, s, *statsFormat) }
+		}
+		if *bind != "" {
+			for _, addr := range strings.Split(*bind, ",") {
+				config.BindAddrs = append(config.BindAddrs, strings.TrimSpace(addr))
+			}
+		}
+		if config.ACL, err = buildACL(*allow, *deny, *allowFile, *denyFile); err != nil {
+			fatal(logger, err)
+		}
+		if *proxyFrom != "" {
+			if config.TrustedProxies, err = acl.New(acl.Split(*proxyFrom), nil); err != nil {
+				fatal(logger, err)
+			}
+		}
+		config.MaxConns = *maxConns
+		if config.ConnPolicy, err = tcp_server.ParseConnPolicy(*connPolicy); err != nil {
+			fatal(logger, err)
+		}
+		if *ipRate != "" {
+			limit, err := tcp_server.ParseRateLimit(*ipRate)
+			if err != nil {
+				fatal(logger, err)
+			}
+			config.PerIPRate = &limit
+		}
+		switch {
+		case *unixSocket:
+			config.Address = args[0]
+			config.Listener = &network.UnixListenerFactory{}
+		case *udpMode:
+			config.Port = args[0]
+			config.Listener = &network.UDPListenerFactory{}
+			config.Datagrams = printer
+		default:
+			config.Port = args[0]
+		}
+		config.DrainTimeout = *drain
+		err = runServer(config)
 
 	} else {
-		// host and port are provided with this syntax host:port
-		parts := strings.Split(args[0], ":")
-		err = runClient(parts[0], parts[1], *requireTLS)
+		config := network.ClientConfig{
+			RemoteAddr:    args[0],
+			RequireTLS:    *requireTLS,
+			SourceAddr:    *sourceAddr,
+			SourcePort:    *sourcePort,
+			SocketOptions: sockOpts,
+			SendPath:      *sendPath,
+			Compression:   algo,
+			Shaping:       shape,
+			Logger:        logger,
+			Telnet:        *telnetMode,
+			TelnetOptions: telnet.Options{
+				Echo:         *telnetEcho,
+				TerminalType: *telnetTerm,
+			},
+		}
+		if *telnetSize != "" {
+			config.TelnetOptions.Width, config.TelnetOptions.Height, err = parseWindowSize(*telnetSize)
+			if err != nil {
+				fatal(logger, err)
+			}
+		} else if *rawMode {
+			// Report the real terminal size when nothing else was requested
+			if size, err := terminal.GetSize(int(os.Stdin.Fd())); err == nil {
+				config.TelnetOptions.Width, config.TelnetOptions.Height = size.Width, size.Height
+			}
+		}
+		if *statsFormat != "" {
+			config.Stats = stats.New()
+		}
+		switch {
+		case *unixSocket:
+			config.Dialer = &network.UnixDialer{}
+		case *udpMode:
+			config.Dialer = &network.UDPDialer{}
+			config.Input = frames
+			config.Datagrams = printer
+		case *proxy != "":
+			config.Dialer = &network.SOCKS5Dialer{Proxy: *proxy}
+		}
+		if *sendProxy != "" {
+			if config.ProxyProtocol, err = parseProxyVersion(*sendProxy); err != nil {
+				fatal(logger, err)
+			}
+		}
+		if *sendProxySrc != "" {
+			if config.ProxySource, err = net.ResolveTCPAddr("tcp", *sendProxySrc); err != nil {
+				fatal(logger, fmt.Errorf("invalid -send-proxy-src: %w", err))
+			}
+		}
+		resolver.Numeric = *numeric
+		resolver.Server = *dnsServer
+		config.Resolver = resolver
+		if *attemptDelay > 0 {
+			config.AttemptDelay = *attemptDelay
+		} else {
+			config.AttemptDelay = -1
+		}
+		if *probeAll {
+			err = probeAddresses(config)
+		} else {
+			err = runClient(config, *rawMode, *statsFormat)
+		}
 	}
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, err)
 	}
 
 }
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// withDevice returns a copy of factory binding its sockets to a network
+// device. A TLS factory binds the listener of its base.
+func withDevice(factory ListenerFactory, device string) (ListenerFactory, error) {
+	switch f := factory.(type) {
+	case *TCPListenerFactory:
+		bound := *f
+		bound.Config.Control = sockopt.Chain(f.Config.Control, sockopt.BindToDevice(device))
+		return &bound, nil
+	case *UDPListenerFactory:
+		bound := *f
+		bound.Config.Control = sockopt.Chain(f.Config.Control, sockopt.BindToDevice(device))
+		return &bound, nil
+	case *TLSListenerFactory:
+		bound := *f
+		base, err := withDevice(orTCPListener(f.Base), device)
+		bound.Base = base
+		return &bound, err
+	case *UnixListenerFactory:
+		return nil, errors.New("a device cannot be used with Unix sockets")
+	}
+	return nil, fmt.Errorf("cannot bind a device with %T", factory)
+}
+
+func orTCPListener(f ListenerFactory) ListenerFactory {
+	if f == nil {
+		return &TCPListenerFactory{}
+	}
+	return f
+}
+
+// resolveBindAddrs expands host names into every address they resolve to,
+// so a name such as localhost listens on both 127.0.0.1 and ::1. Addresses
+// listed twice are only returned once.
+func resolveBindAddrs(ctx context.Context, hosts []string) ([]string, error) {
+	var ips []string
+	seen := make(map[string]bool)
+	add := func(ip string) {
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+
+	for _, host := range hosts {
+		if host == "" || net.ParseIP(host) != nil {
+			add(host)
+			continue
+		}
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			add(addr.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// multiListener accepts connections from several listeners at once
+type multiListener struct {
+	listeners []net.Listener
+	results   chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// newMultiListener merges listeners, it owns them from now on
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		results:   make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go m.acceptLoop(l)
+	}
+	return m
+}
+
+// acceptLoop forwards the connections of l until it fails for good
+func (m *multiListener) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.results <- acceptResult{conn, err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+
+		var temp interface{ Temporary() bool }
+		if err != nil && !(errors.As(err, &temp) && temp.Temporary()) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.results:
+		return r.conn, r.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes every listener and returns the first error
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, l := range m.listeners {
+			if cerr := l.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMultiListener(t *testing.T) {
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("cannot listen on loopback: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	merged := newMultiListener(listeners)
+
+	// A connection to either address comes out of the merged listener
+	for _, l := range listeners {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		conn, err := merged.Accept()
+		if err != nil {
+			t.Fatalf("accept failed: %v", err)
+		}
+		if conn.LocalAddr().String() != l.Addr().String() {
+			t.Errorf("expected a connection on %v, got %v", l.Addr(), conn.LocalAddr())
+		}
+		conn.Close()
+	}
+
+	merged.Close()
+	if _, err := merged.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed after Close, got %v", err)
+	}
+	for _, l := range listeners {
+		if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			conn.Close()
+			t.Errorf("expected %v to be closed", l.Addr())
+		}
+	}
+}
+
+func TestResolveBindAddrs(t *testing.T) {
+	ips, err := resolveBindAddrs(context.Background(), []string{"127.0.0.1", "::1", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || ips[0] != "127.0.0.1" || ips[1] != "::1" {
+		t.Errorf("expected each address once, got %v", ips)
+	}
+}
+
+func TestBindDeviceRejectedForUnix(t *testing.T) {
+	if _, err := withDevice(&UnixListenerFactory{}, "lo"); err == nil {
+		t.Error("expected an error binding a Unix socket to a device")
+	}
+}
+
+func TestOnListenReportsEveryAddress(t *testing.T) {
+	var addrs []string
+	server, err := NewServer(ServerConfig{
+		BindAddrs: []string{"127.0.0.1", "127.0.0.2"},
+		Port:      "0",
+		OnListen:  func(addr net.Addr) { addrs = append(addrs, addr.String()) },
+	})
+	if err != nil {
+		t.Skipf("cannot listen on both loopback addresses: %v", err)
+	}
+	defer server.Close()
+
+	if len(addrs) != 2 || !strings.HasPrefix(addrs[0], "127.0.0.1:") || !strings.HasPrefix(addrs[1], "127.0.0.2:") {
+		t.Fatalf("expected both bound addresses, got %v", addrs)
+	}
+	if strings.HasSuffix(addrs[0], ":0") {
+		t.Errorf("expected the port chosen by the system, got %s", addrs[0])
+	}
+}
@@ -1,12 +1,25 @@
 package network
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
+	"time"
 
-	"github.com/gppmad/gonc/tcp_client"
-	"github.com/gppmad/gonc/tls_client"
+	"github.com/gppmad/gonc/compression"
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/resolve"
+	"github.com/gppmad/gonc/session"
+	"github.com/gppmad/gonc/shaping"
+	"github.com/gppmad/gonc/sockopt"
+	"github.com/gppmad/gonc/stats"
+	"github.com/gppmad/gonc/telnet"
+	"github.com/gppmad/gonc/transfer"
+	"github.com/gppmad/gonc/udp"
 )
 
 // Client defines the common operations for all network clients
@@ -14,6 +27,9 @@ type Client interface {
 	// Start initiates the connection and handles I/O
 	Start() error
 
+	// StartContext is like Start but ends the session when ctx is done
+	StartContext(ctx context.Context) error
+
 	// Close terminates the connection
 	Close() error
 }
@@ -22,27 +38,242 @@ type Client interface {
 type ClientConfig struct {
 	RemoteAddr string
 	RequireTLS bool
+
+	// Dialer opens the connection, TCP when nil. RequireTLS adds TLS on top.
+	Dialer Dialer
+
+	// SourceAddr and SourcePort bind the local end of the connection, the
+	// empty address and port 0 leave the choice to the system
+	SourceAddr string
+	SourcePort int
+
+	// Resolver looks up the host of RemoteAddr, the system resolver when
+	// nil. A SOCKS5 proxy resolves names itself.
+	Resolver *resolve.Resolver
+
+	// ProxyProtocol is the version of a PROXY protocol header sent before
+	// any data, 0 sends none. ProxySource replaces the local address in it.
+	ProxyProtocol int
+	ProxySource   net.Addr
+
+	// AttemptDelay is the head start of each address of a host name before
+	// the next one is tried, DefaultAttemptDelay when 0 and one at a time
+	// when negative (TCP only)
+	AttemptDelay time.Duration
+
+	// SocketOptions tune the socket of the connection
+	SocketOptions sockopt.Options
+
+	// Telnet parses IAC sequences from the connection using TelnetOptions
+	Telnet        bool
+	TelnetOptions telnet.Options
+
+	// SendPath is a file or directory sent with a transfer header instead of stdin
+	SendPath string
+
+	// Compression compresses the stream, the server must use the same algorithm
+	Compression compression.Algorithm
+
+	// Shaping limits and delays both directions of the connection
+	Shaping shaping.Options
+
+	// Stats, when set, counts the traffic of the session
+	Stats *stats.Stats
+
+	// Input and Output of the session, nil means stdin and stdout
+	Input  io.Reader
+	Output io.Writer
+
+	// Datagrams, when set, prints every received datagram with its sender
+	// instead of copying them to Output (UDP)
+	Datagrams *udp.Printer
+
+	// Logger receives connection events, nil discards them
+	Logger *slog.Logger
 }
 
 // NewClient creates a new network client based on config
 func NewClient(config ClientConfig) (Client, error) {
+	return DialContext(context.Background(), config)
+}
 
+// DialContext is like NewClient but gives up connecting, including the TLS
+// handshake and the compression negotiation, when ctx is done
+func DialContext(ctx context.Context, config ClientConfig) (Client, error) {
+	logger := logging.OrDiscard(config.Logger)
+	dialer, err := baseDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	switch dialer.(type) {
+	case *TCPDialer, *UDPDialer:
+		// UDP dials never wait for the peer, the first address wins
+		dialer = &HappyEyeballsDialer{
+			Base:         dialer,
+			Resolver:     config.Resolver,
+			AttemptDelay: config.AttemptDelay,
+			Logger:       config.Logger,
+		}
+	}
+	if config.ProxyProtocol != 0 {
+		dialer = &ProxyHeaderDialer{Base: dialer, Version: config.ProxyProtocol, Source: config.ProxySource}
+	}
 	if config.RequireTLS {
-		// Connect to remote server with a TLS connection.
-		conn, err := tls_client.Connect(config.RemoteAddr, &tls.Config{})
-		if err != nil {
+		dialer = &TLSDialer{Base: dialer}
+	}
+
+	logger.Debug("connecting", "address", config.RemoteAddr)
+	conn, err := dialer.Dial(ctx, config.RemoteAddr)
+	if err != nil {
+		logger.Debug("connection failed", "address", config.RemoteAddr, "error", err)
+		return nil, err
+	}
+
+	tlsConn, isTLS := conn.(*tls.Conn)
+	if isTLS {
+		logHandshake(logger, tlsConn.ConnectionState())
+	}
+	logger.Info("connected", logging.RemoteAddr(conn), logging.LocalAddr(conn), "tls", isTLS)
+
+	// The negotiations in wrapConn read from the peer, a past deadline
+	// unblocks them when ctx is done
+	raw := conn
+	stop := context.AfterFunc(ctx, func() { raw.SetDeadline(time.Unix(1, 0)) })
+	conn, err = wrapConn(raw, config)
+	if !stop() {
+		raw.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Stats != nil {
+		config.Stats.MarkConnected()
+	}
+
+	if config.SendPath != "" {
+		if config.Stats != nil {
+			conn = config.Stats.Conn(conn)
+		}
+		sender := transfer.NewSender(conn, config.SendPath, os.Stderr)
+		if config.Stats != nil {
+			return &statsSender{Sender: sender, stats: config.Stats}, nil
+		}
+		return sender, nil
+	}
+
+	output := config.Output
+	if config.Datagrams != nil {
+		output = config.Datagrams.Writer(raw.RemoteAddr())
+	}
+
+	// Every transport shares the same session
+	client := session.New(conn, config.Input, output)
+	client.Stats = config.Stats
+	client.Logger = config.Logger
+	return client, nil
+}
+
+// statsSender finishes the statistics once the transfer ends, as a session
+// does for a stream
+type statsSender struct {
+	*transfer.Sender
+	stats *stats.Stats
+}
+
+func (s *statsSender) Start() error {
+	return s.StartContext(context.Background())
+}
+
+func (s *statsSender) StartContext(ctx context.Context) error {
+	err := s.Sender.StartContext(ctx)
+	s.stats.Finish(err)
+	return err
+}
+
+// baseDialer returns the dialer of config with the source binding and the
+// socket options applied
+func baseDialer(config ClientConfig) (Dialer, error) {
+	dialer := orTCP(config.Dialer)
+	if config.SourceAddr != "" || config.SourcePort != 0 {
+		var ip net.IP
+		if config.SourceAddr != "" {
+			if ip = net.ParseIP(config.SourceAddr); ip == nil {
+				return nil, fmt.Errorf("invalid source address %q", config.SourceAddr)
+			}
+		}
+
+		var err error
+		if dialer, err = withSource(dialer, ip, config.SourcePort); err != nil {
 			return nil, err
 		}
-		return tls_client.NewTlsClient(conn, os.Stdin, os.Stdout), nil
-	} else {
-		// Connect to remote server using a standard TCP connection
-		conn, err := net.Dial("tcp", config.RemoteAddr)
+		logging.OrDiscard(config.Logger).Debug("binding source", "address", config.SourceAddr, "port", config.SourcePort)
+	}
+	if !config.SocketOptions.IsZero() {
+		var err error
+		if dialer, err = withSocketOptions(dialer, config.SocketOptions); err != nil {
+			return nil, err
+		}
+	}
+	return dialer, nil
+}
+
+// logHandshake reports the outcome of a TLS handshake
+func logHandshake(logger *slog.Logger, state tls.ConnectionState) {
+	attrs := []any{
+		"version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+		"server_name", state.ServerName,
+	}
+	if state.NegotiatedProtocol != "" {
+		attrs = append(attrs, "alpn", state.NegotiatedProtocol)
+	}
+	if len(state.PeerCertificates) > 0 {
+		attrs = append(attrs, "peer_subject", state.PeerCertificates[0].Subject.String())
+	}
+	logger.Info("TLS handshake complete", attrs...)
+}
+
+// wrapConn layers the optional stream transformations on top of conn:
+// traffic shaping on the wire, then compression and the telnet control stream
+func wrapConn(conn net.Conn, config ClientConfig) (net.Conn, error) {
+	if config.Shaping.Enabled() {
+		conn = shaping.NewConn(conn, config.Shaping, config.Shaping)
+	}
+
+	if config.Compression != compression.None {
+		compressed, err := compression.Wrap(conn, config.Compression)
 		if err != nil {
+			conn.Close()
 			return nil, err
 		}
+		conn = compressed
+	}
+
+	if config.Telnet {
+		conn = telnet.NewConn(conn, config.TelnetOptions)
+	}
+
+	return conn, nil
+}
+
+// windowSizer is implemented by connections able to report the local
+// terminal size to the remote side, such as telnet with NAWS
+type windowSizer interface {
+	SetWindowSize(width, height uint16) error
+}
 
-		// Create and return TCP client
-		return tcp_client.NewTcpClient(conn, os.Stdin, os.Stdout), nil
+// ResizeWindow forwards a terminal size change to the remote side when the
+// client protocol supports it. It does nothing otherwise.
+func ResizeWindow(client Client, width, height uint16) error {
+	s, ok := client.(*session.Session)
+	if !ok {
+		return nil
 	}
 
+	if sizer, ok := s.Conn.(windowSizer); ok {
+		return sizer.SetWindowSize(width, height)
+	}
+	return nil
 }
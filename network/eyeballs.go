@@ -0,0 +1,248 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/resolve"
+)
+
+// DefaultAttemptDelay is the head start of a connection attempt before the
+// next address is tried, as recommended by RFC 8305
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// HappyEyeballsDialer resolves host names itself and races connections to
+// the addresses (RFC 8305). IPv6 and IPv4 addresses alternate, each attempt
+// gets AttemptDelay of head start over the next one, or less when it fails
+// first, and the first connection established wins.
+type HappyEyeballsDialer struct {
+	// Base dials each address, TCP when nil
+	Base Dialer
+
+	// Resolver looks up host names, the system resolver when nil
+	Resolver *resolve.Resolver
+
+	// AttemptDelay is DefaultAttemptDelay when 0, a negative delay tries
+	// the addresses one at a time
+	AttemptDelay time.Duration
+
+	// Logger receives every attempt and its outcome, nil discards them
+	Logger *slog.Logger
+}
+
+// DialError reports why every address of a host failed
+type DialError struct {
+	Host string
+	Errs []error
+}
+
+func (e *DialError) Error() string {
+	reasons := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		reasons[i] = err.Error()
+	}
+	return fmt.Sprintf("cannot connect to %s: %s", e.Host, strings.Join(reasons, "; "))
+}
+
+func (e *DialError) Unwrap() []error {
+	return e.Errs
+}
+
+func (d *HappyEyeballsDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	base := orTCP(d.Base)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return base.Dial(ctx, address)
+	}
+
+	addresses, err := resolveHost(ctx, d.Resolver, host, port)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.OrDiscard(d.Logger)
+	logger.Debug("DNS resolution", "host", host, "addresses", addresses)
+
+	conn, errs := d.race(ctx, base, addresses, logger)
+	if conn != nil {
+		return conn, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, &DialError{Host: host, Errs: errs}
+}
+
+// dialResult is the outcome of one connection attempt
+type dialResult struct {
+	address string
+	conn    net.Conn
+	err     error
+}
+
+// race dials addresses in order, starting the next attempt when the delay
+// passes or an attempt fails. It returns the first connection, or the
+// error of every attempt.
+func (d *HappyEyeballsDialer) race(ctx context.Context, base Dialer, addresses []string, logger *slog.Logger) (net.Conn, []error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := d.AttemptDelay
+	if delay == 0 {
+		delay = DefaultAttemptDelay
+	}
+
+	// Buffered so the attempts still running after a winner never block
+	results := make(chan dialResult, len(addresses))
+	next, pending := 0, 0
+	startNext := func() {
+		address := addresses[next]
+		next++
+		pending++
+		logger.Info("connection attempt", "address", address)
+		go func() {
+			conn, err := base.Dial(ctx, address)
+			results <- dialResult{address: address, conn: conn, err: err}
+		}()
+	}
+
+	var errs []error
+	startNext()
+	for pending > 0 {
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if delay > 0 && next < len(addresses) {
+			timer = time.NewTimer(delay)
+			timeout = timer.C
+		}
+
+		var r dialResult
+		select {
+		case <-timeout:
+			startNext()
+			continue
+		case r = <-results:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		pending--
+		if r.err == nil {
+			cancel()
+			go closeLosers(results, pending)
+			return r.conn, nil
+		}
+		logger.Info("connection attempt failed", "address", r.address, "error", r.err)
+		errs = append(errs, r.err)
+		if next < len(addresses) && ctx.Err() == nil {
+			startNext()
+		}
+	}
+	return nil, errs
+}
+
+// closeLosers closes the connections of attempts still running when
+// another one won
+func closeLosers(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// resolveHost looks up host and returns its addresses joined with port,
+// families interleaved starting with the one the resolver preferred
+func resolveHost(ctx context.Context, resolver *resolve.Resolver, host, port string) ([]string, error) {
+	ips, err := resolver.LookupIPAddr(ctx, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(ips))
+	for i, ip := range interleaveFamilies(ips) {
+		addresses[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addresses, nil
+}
+
+// interleaveFamilies alternates IPv6 and IPv4 addresses, starting with the
+// family of the first one and otherwise keeping their order
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	for _, ip := range ips {
+		if len(first) == 0 || (ip.IP.To4() == nil) == (first[0].IP.To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+
+	sorted := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+// ProbeResult is the outcome of connecting to one address
+type ProbeResult struct {
+	Address  string
+	Duration time.Duration
+	Err      error
+}
+
+// ProbeAll connects to every address RemoteAddr resolves to in turn,
+// including the TLS handshake when RequireTLS is set, and closes each
+// connection right away. Only direct TCP connections can be probed.
+func ProbeAll(ctx context.Context, config ClientConfig) ([]ProbeResult, error) {
+	base, err := baseDialer(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := base.(*TCPDialer); !ok {
+		return nil, fmt.Errorf("cannot probe every address with %T", base)
+	}
+
+	host, port, err := net.SplitHostPort(config.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := resolveHost(ctx, config.Resolver, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := base
+	if config.RequireTLS {
+		dialer = &TLSDialer{Base: base, Config: &tls.Config{ServerName: host}}
+	}
+
+	results := make([]ProbeResult, len(addresses))
+	for i, address := range addresses {
+		start := time.Now()
+		conn, err := dialer.Dial(ctx, address)
+		results[i] = ProbeResult{Address: address, Duration: time.Since(start), Err: err}
+		if err == nil {
+			conn.Close()
+		}
+	}
+	return results, nil
+}
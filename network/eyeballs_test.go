@@ -0,0 +1,179 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/resolve"
+)
+
+// scriptedDialer answers each address with a delay and an optional error,
+// addresses missing from the script hang until the attempt is cancelled
+type scriptedDialer struct {
+	delays map[string]time.Duration
+	errs   map[string]error
+}
+
+func (d *scriptedDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	delay, ok := d.delays[address]
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := d.errs[address]; err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return &addrConn{Conn: client, remote: address}, nil
+}
+
+// addrConn reports the address it was dialed with
+type addrConn struct {
+	net.Conn
+	remote string
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.remote)
+	return addr
+}
+
+func TestRaceSlowAddressLoses(t *testing.T) {
+	d := &HappyEyeballsDialer{AttemptDelay: 20 * time.Millisecond}
+	base := &scriptedDialer{delays: map[string]time.Duration{"127.0.0.2:80": 0}}
+
+	start := time.Now()
+	conn, errs := d.race(context.Background(), base, []string{"[::1]:80", "127.0.0.2:80"}, logging.OrDiscard(nil))
+	if conn == nil {
+		t.Fatalf("expected a connection, got %v", errs)
+	}
+	defer conn.Close()
+
+	if got := conn.RemoteAddr().String(); got != "127.0.0.2:80" {
+		t.Errorf("expected the second address to win, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("the second attempt started after %v, before its delay", elapsed)
+	}
+}
+
+func TestRaceFailureStartsNextAttempt(t *testing.T) {
+	refused := errors.New("refused")
+	d := &HappyEyeballsDialer{AttemptDelay: time.Hour}
+	base := &scriptedDialer{
+		delays: map[string]time.Duration{"[::1]:80": 0, "127.0.0.2:80": 0},
+		errs:   map[string]error{"[::1]:80": refused},
+	}
+
+	conn, errs := d.race(context.Background(), base, []string{"[::1]:80", "127.0.0.2:80"}, logging.OrDiscard(nil))
+	if conn == nil {
+		t.Fatalf("expected the next address to be tried right away, got %v", errs)
+	}
+	conn.Close()
+}
+
+func TestRaceReportsEveryFailure(t *testing.T) {
+	refused := errors.New("refused")
+	addresses := []string{"[::1]:80", "127.0.0.2:80", "127.0.0.3:80"}
+	base := &scriptedDialer{delays: map[string]time.Duration{}, errs: map[string]error{}}
+	for _, address := range addresses {
+		base.delays[address] = 0
+		base.errs[address] = refused
+	}
+
+	d := &HappyEyeballsDialer{AttemptDelay: -1}
+	conn, errs := d.race(context.Background(), base, addresses, logging.OrDiscard(nil))
+	if conn != nil {
+		t.Fatal("expected every attempt to fail")
+	}
+	if len(errs) != len(addresses) {
+		t.Errorf("expected %d errors, got %v", len(addresses), errs)
+	}
+
+	err := &DialError{Host: "example.com", Errs: errs}
+	if !errors.Is(err, refused) {
+		t.Errorf("expected the DialError to wrap the attempt errors, got %v", err)
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	ip := func(s string) net.IPAddr { return net.IPAddr{IP: net.ParseIP(s)} }
+	got := interleaveFamilies([]net.IPAddr{ip("::1"), ip("::2"), ip("::3"), ip("10.0.0.1"), ip("10.0.0.2")})
+	want := []net.IPAddr{ip("::1"), ip("10.0.0.1"), ip("::2"), ip("10.0.0.2"), ip("::3")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interleaveFamilies = %v, want %v", got, want)
+	}
+}
+
+func TestProbeAll(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	address := listener.Addr().String()
+
+	results, err := ProbeAll(context.Background(), ClientConfig{RemoteAddr: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Address != address || results[0].Err != nil {
+		t.Errorf("expected %s to be reported up, got %+v", address, results)
+	}
+
+	listener.Close()
+	results, err = ProbeAll(context.Background(), ClientConfig{RemoteAddr: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("expected %s to be reported down, got %+v", address, results)
+	}
+}
+
+func TestResolverOverride(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	resolver := &resolve.Resolver{Numeric: true}
+	if err := resolver.AddOverride("gonc.test:" + port + ":127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialContext(context.Background(), ClientConfig{
+		RemoteAddr: net.JoinHostPort("gonc.test", port),
+		Resolver:   resolver,
+	})
+	if err != nil {
+		t.Fatalf("expected the override to be dialed, got %v", err)
+	}
+	client.Close()
+
+	// Without an override numeric mode refuses the name
+	_, err = DialContext(context.Background(), ClientConfig{
+		RemoteAddr: net.JoinHostPort("localhost", port),
+		Resolver:   resolver,
+	})
+	if !errors.Is(err, resolve.ErrNumeric) {
+		t.Errorf("expected numeric mode to refuse localhost, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// withSocketOptions returns a copy of dialer setting options on its
+// sockets. TLS and SOCKS5 dialers set them on their base dialer.
+func withSocketOptions(dialer Dialer, options sockopt.Options) (Dialer, error) {
+	switch d := dialer.(type) {
+	case *TCPDialer:
+		tuned := *d
+		tuned.Options = options
+		return &tuned, nil
+	case *UDPDialer:
+		tuned := *d
+		tuned.Options = options
+		return &tuned, nil
+	case *TLSDialer:
+		tuned := *d
+		base, err := withSocketOptions(orTCP(d.Base), options)
+		tuned.Base = base
+		return &tuned, err
+	case *SOCKS5Dialer:
+		tuned := *d
+		base, err := withSocketOptions(orTCP(d.Base), options)
+		tuned.Base = base
+		return &tuned, err
+	case *UnixDialer:
+		return nil, errors.New("socket options cannot be used with Unix sockets")
+	}
+	return nil, fmt.Errorf("cannot set socket options with %T", dialer)
+}
+
+// withListenOptions is withSocketOptions for listener factories
+func withListenOptions(factory ListenerFactory, options sockopt.Options) (ListenerFactory, error) {
+	switch f := factory.(type) {
+	case *TCPListenerFactory:
+		tuned := *f
+		tuned.Options = options
+		return &tuned, nil
+	case *UDPListenerFactory:
+		tuned := *f
+		tuned.Options = options
+		return &tuned, nil
+	case *TLSListenerFactory:
+		tuned := *f
+		base, err := withListenOptions(orTCPListener(f.Base), options)
+		tuned.Base = base
+		return &tuned, err
+	case *UnixListenerFactory:
+		return nil, errors.New("socket options cannot be used with Unix sockets")
+	}
+	return nil, fmt.Errorf("cannot set socket options with %T", factory)
+}
+
+// dialWithOptions dials with options set on the socket. Go turns on
+// keepalive with its own timings unless KeepAlive is negative, and always
+// sets TCP_NODELAY once connected, so both are handled here.
+func dialWithOptions(ctx context.Context, dialer net.Dialer, options sockopt.Options, network, address string) (net.Conn, error) {
+	if options.IsZero() {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	dialer.Control = sockopt.Chain(dialer.Control, options.Control())
+	if options.KeepAliveSet() {
+		dialer.KeepAlive = -1
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.Apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listenConfigWithOptions returns config setting options on the listening
+// socket, accepted sockets inherit them
+func listenConfigWithOptions(config net.ListenConfig, options sockopt.Options) net.ListenConfig {
+	if options.IsZero() {
+		return config
+	}
+
+	config.Control = sockopt.Chain(config.Control, options.Control())
+	if options.KeepAliveSet() {
+		config.KeepAlive = -1
+	}
+	return config
+}
+
+// optionsListener applies the options Go resets on every accepted
+// connection. A connection that cannot be tuned, usually because the peer
+// already went away, is dropped without failing the listener.
+type optionsListener struct {
+	net.Listener
+	options sockopt.Options
+}
+
+func (l *optionsListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err := l.options.Apply(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
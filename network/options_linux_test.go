@@ -0,0 +1,84 @@
+package network
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// getsockopt reads an integer option from a TCP connection
+func getsockopt(t *testing.T, conn net.Conn, level, name int) int {
+	t.Helper()
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	raw.Control(func(fd uintptr) {
+		value, err = syscall.GetsockoptInt(int(fd), level, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// Go sets its own keepalive timing and TCP_NODELAY on every connection, the
+// configured options must survive both on the dialed and the accepted end
+func TestSocketOptionsSurviveGoDefaults(t *testing.T) {
+	options := sockopt.Options{KeepAlive: 42 * time.Second, Nagle: true}
+
+	factory, err := withListenOptions(&TCPListenerFactory{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := factory.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	dialer, err := withSocketOptions(&TCPDialer{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial(context.Background(), listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer := <-accepted
+	defer peer.Close()
+
+	for name, c := range map[string]net.Conn{"dialed": conn, "accepted": peer} {
+		if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 42 {
+			t.Errorf("%s: TCP_KEEPIDLE = %d, want 42", name, got)
+		}
+		if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+			t.Errorf("%s: TCP_NODELAY = %d, want Nagle enabled", name, got)
+		}
+	}
+}
+
+func TestSocketOptionsRejectedForUnix(t *testing.T) {
+	_, err := DialContext(context.Background(), ClientConfig{
+		RemoteAddr:    "/tmp/none.sock",
+		Dialer:        &UnixDialer{},
+		SocketOptions: sockopt.Options{SendBuffer: 4096},
+	})
+	if err == nil {
+		t.Error("expected socket options to be rejected for Unix sockets")
+	}
+}
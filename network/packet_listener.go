@@ -0,0 +1,284 @@
+package network
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 64 * 1024
+
+// Datagrams queued for a peer that does not read are dropped past this
+const peerQueue = 64
+
+// PacketListener demultiplexes a packet socket by remote address. The first
+// datagram of a new peer makes Accept return a connection for it, later
+// datagrams are read from that connection.
+type PacketListener struct {
+	pc          net.PacketConn
+	idleTimeout time.Duration
+	accept      chan *packetConn
+	closed      chan struct{}
+	closeOnce   sync.Once
+
+	mu    sync.Mutex
+	peers map[string]*packetConn
+}
+
+// NewPacketListener starts reading from pc, the listener owns it from now on
+func NewPacketListener(pc net.PacketConn, idleTimeout time.Duration) *PacketListener {
+	l := &PacketListener{
+		pc:          pc,
+		idleTimeout: idleTimeout,
+		accept:      make(chan *packetConn),
+		closed:      make(chan struct{}),
+		peers:       make(map[string]*packetConn),
+	}
+	go l.readLoop()
+	if idleTimeout > 0 {
+		go l.expireLoop()
+	}
+	return l
+}
+
+func (l *PacketListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener, connections of known peers get EOF
+func (l *PacketListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.pc.Close()
+
+		l.mu.Lock()
+		peers := l.peers
+		l.peers = map[string]*packetConn{}
+		l.mu.Unlock()
+		for _, c := range peers {
+			c.shutdown(true)
+		}
+	})
+	return err
+}
+
+func (l *PacketListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// readLoop dispatches every datagram to the connection of its sender
+func (l *PacketListener) readLoop() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+
+		l.mu.Lock()
+		c, known := l.peers[addr.String()]
+		if !known {
+			c = newPacketConn(l, addr)
+			l.peers[addr.String()] = c
+		}
+		l.mu.Unlock()
+
+		c.deliver(append([]byte(nil), buf[:n]...))
+		if !known {
+			select {
+			case l.accept <- c:
+			case <-l.closed:
+				return
+			}
+		}
+	}
+}
+
+// expireLoop closes the peers that stayed silent for the idle timeout
+func (l *PacketListener) expireLoop() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			var idle []*packetConn
+			for _, c := range l.peers {
+				if now.Sub(time.Unix(0, c.lastActive.Load())) >= l.idleTimeout {
+					idle = append(idle, c)
+				}
+			}
+			l.mu.Unlock()
+
+			for _, c := range idle {
+				c.shutdown(true)
+			}
+		}
+	}
+}
+
+func (l *PacketListener) forget(c *packetConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.peers[c.remote.String()] == c {
+		delete(l.peers, c.remote.String())
+	}
+}
+
+// packetConn is the connection of a single peer of a PacketListener
+type packetConn struct {
+	listener   *PacketListener
+	remote     net.Addr
+	packets    chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+	lastActive atomic.Int64
+
+	mu       sync.Mutex
+	eof      bool      // Closed by the listener rather than by Close
+	pending  []byte    // Rest of a datagram larger than the last read
+	deadline time.Time // Read deadline
+	wake     chan struct{}
+}
+
+func newPacketConn(l *PacketListener, remote net.Addr) *packetConn {
+	c := &packetConn{
+		listener: l,
+		remote:   remote,
+		packets:  make(chan []byte, peerQueue),
+		done:     make(chan struct{}),
+		wake:     make(chan struct{}),
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c
+}
+
+// deliver queues a datagram, it is dropped when the reader fell behind
+func (c *packetConn) deliver(p []byte) {
+	c.lastActive.Store(time.Now().UnixNano())
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		deadline, wake := c.deadline, c.wake
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		n, retry, err := c.wait(p, timeout, wake)
+		if timer != nil {
+			timer.Stop()
+		}
+		if !retry {
+			return n, err
+		}
+	}
+}
+
+// wait blocks for a datagram, the end of the connection, the deadline or a
+// change of the deadline, which asks the caller to retry
+func (c *packetConn) wait(p []byte, timeout <-chan time.Time, wake chan struct{}) (n int, retry bool, err error) {
+	select {
+	case packet := <-c.packets:
+		n := copy(p, packet)
+		c.mu.Lock()
+		c.pending = packet[n:]
+		c.mu.Unlock()
+		return n, false, nil
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.eof {
+			return 0, false, io.EOF
+		}
+		return 0, false, net.ErrClosed
+	case <-timeout:
+		return 0, false, os.ErrDeadlineExceeded
+	case <-wake:
+		return 0, true, nil
+	}
+}
+
+func (c *packetConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return c.listener.pc.WriteTo(p, c.remote)
+}
+
+func (c *packetConn) Close() error {
+	c.shutdown(false)
+	return nil
+}
+
+// shutdown ends the connection, eof tells readers the peer went away
+func (c *packetConn) shutdown(eof bool) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.eof = eof
+		c.mu.Unlock()
+		close(c.done)
+		c.listener.forget(c)
+	})
+}
+
+func (c *packetConn) LocalAddr() net.Addr  { return c.listener.pc.LocalAddr() }
+func (c *packetConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.wake)
+	c.wake = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is accepted but has no effect, datagram writes do not block
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -1,10 +1,24 @@
 package network
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
+	"time"
 
+	"github.com/gppmad/gonc/acl"
+	"github.com/gppmad/gonc/compression"
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/shaping"
+	"github.com/gppmad/gonc/sockopt"
+	"github.com/gppmad/gonc/stats"
 	"github.com/gppmad/gonc/tcp_server"
+	"github.com/gppmad/gonc/transfer"
+	"github.com/gppmad/gonc/udp"
 )
 
 // Server defines the common operations for all network servers
@@ -12,8 +26,14 @@ type Server interface {
 	// Start begins accepting connections and handling them
 	Start() error
 
+	// ServeContext is like Start but closes the server when ctx is done
+	ServeContext(ctx context.Context) error
+
 	// Close stops the server and closes the listener
 	Close() error
+
+	// Shutdown stops accepting and waits for active connections until ctx is done
+	Shutdown(ctx context.Context) error
 }
 
 // ServerConfig contains server configuration parameters
@@ -21,19 +41,201 @@ type ServerConfig struct {
 	IP         string
 	Port       string
 	RequireTLS bool
+
+	// BindAddrs listens on each of these addresses or host names with
+	// Port, IP is used when it is empty. Names listen on every address they
+	// resolve to.
+	BindAddrs []string
+
+	// BindDevice restricts the listener to a network device (Linux only)
+	BindDevice string
+
+	// SocketOptions tune the listening socket and the accepted connections
+	SocketOptions sockopt.Options
+
+	// Address, when set, is used instead of IP and Port, such as the path
+	// of a Unix socket
+	Address string
+
+	// Listener opens the listener, TCP when nil. RequireTLS adds TLS on top
+	// of it with the certificate in CertFile and KeyFile.
+	Listener ListenerFactory
+	CertFile string
+	KeyFile  string
+
+	// RecvDir stores incoming transfers in this directory instead of
+	// copying connections to stdout
+	RecvDir string
+
+	// Compression compresses the stream, clients must use the same algorithm
+	Compression compression.Algorithm
+
+	// Shaping limits and delays both directions of every connection
+	Shaping shaping.Options
+
+	// Input and Output are shared by the connections, nil means stdin and stdout
+	Input  io.Reader
+	Output io.Writer
+
+	// Datagrams, when set, prints every received datagram with its sender
+	// instead of copying them to Output (UDP)
+	Datagrams *udp.Printer
+
+	// OnStats, when set, receives the statistics of every closed connection
+	OnStats func(*stats.Stats)
+
+	// Logger receives listener and connection events, nil discards them
+	Logger *slog.Logger
+
+	// ProxyProtocol expects a PROXY protocol header, v1 or v2, before the
+	// data and TLS handshake of every connection
+	ProxyProtocol bool
+
+	// TrustedProxies, when set, lists the peers allowed to send the PROXY
+	// protocol header, see tcp_server.TcpServer
+	TrustedProxies *acl.List
+
+	// ReverseDNS looks up the host name of every client
+	ReverseDNS bool
+
+	// OnListen, when set, receives every address the server listens on,
+	// with the ports chosen by the system filled in
+	OnListen func(addr net.Addr)
+
+	// OnAccept, when set, receives the information of every accepted
+	// connection, including the TLS client certificate when one was sent
+	OnAccept func(info *tcp_server.ConnInfo)
+
+	// ACL restricts which remote addresses may connect, nil allows everyone
+	ACL *acl.List
+
+	// MaxConns bounds the concurrent connections, 0 means unlimited
+	MaxConns   int
+	ConnPolicy tcp_server.ConnPolicy
+
+	// PerIPRate limits how often a single source IP may connect
+	PerIPRate *tcp_server.RateLimit
+
+	// DrainTimeout bounds how long Close waits for active connections before
+	// closing them, 0 leaves them running
+	DrainTimeout time.Duration
+
+	// Middleware wraps the connection handler, it sees the decoded stream
+	Middleware []tcp_server.Middleware
+
+	// OnError, when set, receives the error of every failed connection
+	OnError func(conn net.Conn, err error)
+}
+
+// listenAddrs returns the addresses to listen on: Address alone when set,
+// otherwise every bind address, or IP, combined with Port
+func listenAddrs(config ServerConfig) ([]string, error) {
+	if config.Address != "" {
+		return []string{config.Address}, nil
+	}
+
+	hosts := config.BindAddrs
+	if len(hosts) == 0 {
+		hosts = []string{config.IP}
+	}
+	ips, err := resolveBindAddrs(context.Background(), hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.JoinHostPort(ip, config.Port)
+	}
+	return addresses, nil
 }
 
 // NewServer creates a new network server based on config
 func NewServer(config ServerConfig) (Server, error) {
-	// Construct the full address with IP and port
-	address := net.JoinHostPort(config.IP, config.Port)
+	factory := orTCPListener(config.Listener)
+	if config.BindDevice != "" {
+		var err error
+		if factory, err = withDevice(factory, config.BindDevice); err != nil {
+			return nil, err
+		}
+	}
+	if !config.SocketOptions.IsZero() {
+		var err error
+		if factory, err = withListenOptions(factory, config.SocketOptions); err != nil {
+			return nil, err
+		}
+	}
+	if config.ProxyProtocol {
+		factory = &ProxyListenerFactory{Base: factory}
+	}
+	if config.RequireTLS {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS listen mode needs a certificate and a key: %w", err)
+		}
+		// Client certificates are requested, not verified, so they can be shown
+		factory = &TLSListenerFactory{Base: factory, Config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequestClientCert,
+		}}
+	}
 
-	// Create a standard TCP listener
-	listener, err := net.Listen("tcp", address)
+	addresses, err := listenAddrs(config)
 	if err != nil {
 		return nil, err
 	}
 
+	var listeners []net.Listener
+	for _, address := range addresses {
+		l, err := factory.Listen(context.Background(), address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		logging.OrDiscard(config.Logger).Info("listening", "address", l.Addr().String())
+		listeners = append(listeners, l)
+	}
+	if config.OnListen != nil {
+		for _, l := range listeners {
+			config.OnListen(l.Addr())
+		}
+	}
+
+	listener := listeners[0]
+	if len(listeners) > 1 {
+		listener = newMultiListener(listeners)
+	}
+
 	// Create and return TCP server
-	return tcp_server.NewTcpServer(listener, os.Stdin, os.Stdout), nil
+	server := tcp_server.NewTcpServer(listener, config.Input, config.Output)
+	server.Logger = config.Logger
+	server.ReverseDNS = config.ReverseDNS
+	server.OnAccept = config.OnAccept
+	server.ACL = config.ACL
+	server.TrustedProxies = config.TrustedProxies
+	server.MaxConns = config.MaxConns
+	server.ConnPolicy = config.ConnPolicy
+	server.PerIPRate = config.PerIPRate
+	server.DrainTimeout = config.DrainTimeout
+	server.OnError = config.OnError
+	if config.RecvDir != "" {
+		server.Handler = transfer.ReceiveHandler(config.RecvDir, os.Stderr)
+	}
+	if config.Datagrams != nil {
+		server.Handler = udp.Handler(config.Datagrams, server.Handler)
+	}
+	server.Use(config.Middleware...)
+	if config.OnStats != nil {
+		server.Handler = stats.Handler(config.OnStats, server.Handler)
+	}
+	if config.Compression != compression.None {
+		server.Handler = compression.Handler(config.Compression, server.Handler)
+	}
+	if config.Shaping.Enabled() {
+		// Shaping wraps last so it applies to the bytes on the wire
+		server.Handler = shaping.Handler(config.Shaping, config.Shaping, server.Handler)
+	}
+	return server, nil
 }
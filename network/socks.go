@@ -0,0 +1,187 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol values, RFC 1928 and RFC 1929
+const (
+	socksVersion      = 5
+	socksAuthNone     = 0
+	socksAuthPassword = 2
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksAddrIPv4     = 1
+	socksAddrDomain   = 3
+	socksAddrIPv6     = 4
+)
+
+// SOCKS5Dialer connects through a SOCKS5 proxy. The proxy itself is reached
+// with Base, TCP when nil. Host names are resolved by the proxy.
+type SOCKS5Dialer struct {
+	Proxy string
+	Base  Dialer
+
+	// Username and Password, when set, authenticate with the proxy
+	Username string
+	Password string
+}
+
+func (d *SOCKS5Dialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	base := d.Base
+	if base == nil {
+		base = &TCPDialer{}
+	}
+	conn, err := base.Dial(ctx, d.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	// The negotiation is bounded by ctx like the dial was
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	err = d.connect(conn, host, uint16(port))
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s: %w", d.Proxy, err)
+	}
+	return conn, nil
+}
+
+// connect authenticates and asks the proxy to connect to host:port
+func (d *SOCKS5Dialer) connect(conn net.Conn, host string, port uint16) error {
+	method := byte(socksAuthNone)
+	if d.Username != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	if reply[1] == socksNoAcceptable || reply[1] != method {
+		return errors.New("no acceptable authentication method")
+	}
+
+	if method == socksAuthPassword {
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() {
+			req = append(req, socksAddrIPv4)
+		} else {
+			req = append(req, socksAddrIPv6)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		req = append(req, socksAddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Version, status, reserved and the type of the bound address
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("connect failed: %s", socksStatus(head[1]))
+	}
+
+	var skip int
+	switch head[3] {
+	case socksAddrIPv4:
+		skip = net.IPv4len
+	case socksAddrIPv6:
+		skip = net.IPv6len
+	case socksAddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unexpected address type %d", head[3])
+	}
+	// The bound address and port are not needed
+	_, err := io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// authenticate sends the username and password, RFC 1929
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("username or password too long")
+	}
+
+	req := []byte{1, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// socksStatus describes a SOCKS5 reply code
+func socksStatus(code byte) string {
+	statuses := map[byte]string{
+		1: "general failure",
+		2: "connection not allowed by ruleset",
+		3: "network unreachable",
+		4: "host unreachable",
+		5: "connection refused",
+		6: "TTL expired",
+		7: "command not supported",
+		8: "address type not supported",
+	}
+	if s, ok := statuses[code]; ok {
+		return s
+	}
+	return fmt.Sprintf("status %d", code)
+}
@@ -0,0 +1,55 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// withSource returns a copy of dialer binding its sockets to ip and port.
+// A zero port lets the system pick one, a nil ip any local address. TLS and
+// SOCKS5 dialers bind the connection of their base dialer.
+func withSource(dialer Dialer, ip net.IP, port int) (Dialer, error) {
+	switch d := dialer.(type) {
+	case *TCPDialer:
+		bound := *d
+		bindDialer(&bound.Dialer, &net.TCPAddr{IP: ip, Port: port}, port)
+		return &bound, nil
+	case *UDPDialer:
+		bound := *d
+		bindDialer(&bound.Dialer, &net.UDPAddr{IP: ip, Port: port}, port)
+		return &bound, nil
+	case *TLSDialer:
+		bound := *d
+		base, err := withSource(orTCP(d.Base), ip, port)
+		bound.Base = base
+		return &bound, err
+	case *SOCKS5Dialer:
+		bound := *d
+		base, err := withSource(orTCP(d.Base), ip, port)
+		bound.Base = base
+		return &bound, err
+	case *UnixDialer:
+		return nil, errors.New("a source address cannot be used with Unix sockets")
+	}
+	return nil, fmt.Errorf("cannot bind a source address with %T", dialer)
+}
+
+// bindDialer sets the local address of d. A fixed port also needs
+// SO_REUSEADDR, otherwise it stays busy while the last connection from it
+// is in TIME_WAIT.
+func bindDialer(d *net.Dialer, addr net.Addr, port int) {
+	d.LocalAddr = addr
+	if port != 0 {
+		d.Control = sockopt.Chain(d.Control, sockopt.ReuseAddr)
+	}
+}
+
+func orTCP(d Dialer) Dialer {
+	if d == nil {
+		return &TCPDialer{}
+	}
+	return d
+}
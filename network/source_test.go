@@ -0,0 +1,63 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSourceAddressAndPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Addr, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn.RemoteAddr()
+			conn.Close()
+		}
+	}()
+
+	// Find a free port to connect from
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	// Twice from the same port, the second bind needs SO_REUSEADDR
+	for i := 0; i < 2; i++ {
+		client, err := DialContext(context.Background(), ClientConfig{
+			RemoteAddr: listener.Addr().String(),
+			SourceAddr: "127.0.0.1",
+			SourcePort: port,
+		})
+		if err != nil {
+			t.Fatalf("dial %d failed: %v", i+1, err)
+		}
+		client.Close()
+
+		remote := (<-accepted).(*net.TCPAddr)
+		if remote.Port != port || !remote.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("expected the connection from 127.0.0.1:%d, got %v", port, remote)
+		}
+	}
+}
+
+func TestSourceAddressRejectedForUnix(t *testing.T) {
+	_, err := DialContext(context.Background(), ClientConfig{
+		RemoteAddr: "/tmp/none.sock",
+		Dialer:     &UnixDialer{},
+		SourceAddr: "127.0.0.1",
+	})
+	if err == nil {
+		t.Error("expected an error binding a Unix socket to an IP address")
+	}
+}
@@ -0,0 +1,213 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gppmad/gonc/proxyproto"
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// Dialer opens the connection of a client. Implementations can wrap each
+// other, for example TLS on top of a SOCKS proxy.
+type Dialer interface {
+	Dial(ctx context.Context, address string) (net.Conn, error)
+}
+
+// ListenerFactory opens the listener of a server
+type ListenerFactory interface {
+	Listen(ctx context.Context, address string) (net.Listener, error)
+}
+
+// TCPDialer dials plain TCP connections with the socket options in Options
+type TCPDialer struct {
+	Dialer  net.Dialer
+	Options sockopt.Options
+}
+
+func (d *TCPDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	return dialWithOptions(ctx, d.Dialer, d.Options, "tcp", address)
+}
+
+// UDPDialer dials connected UDP sockets, every write is a datagram
+type UDPDialer struct {
+	Dialer  net.Dialer
+	Options sockopt.Options
+}
+
+func (d *UDPDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	return dialWithOptions(ctx, d.Dialer, d.Options, "udp", address)
+}
+
+// UnixDialer connects to a Unix domain socket, address is its path
+type UnixDialer struct {
+	Dialer net.Dialer
+}
+
+func (d *UnixDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	return d.Dialer.DialContext(ctx, "unix", address)
+}
+
+// ProxyHeaderDialer sends a PROXY protocol header on the connections of
+// Base, TCP when nil, as a load balancer would. The header carries Source,
+// or the local address when nil, and the address connected to.
+type ProxyHeaderDialer struct {
+	Base    Dialer
+	Version int
+	Source  net.Addr
+}
+
+func (d *ProxyHeaderDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := orTCP(d.Base).Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &proxyproto.Header{Version: d.Version, Source: d.Source, Destination: conn.RemoteAddr()}
+	if header.Source == nil {
+		header.Source = conn.LocalAddr()
+	}
+	encoded, err := header.Format()
+	if err == nil {
+		_, err = conn.Write(encoded)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// TLSDialer runs a TLS handshake over the connections of Base, TCP when nil.
+// The server name defaults to the host of the address.
+type TLSDialer struct {
+	Base   Dialer
+	Config *tls.Config
+}
+
+func (d *TLSDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	config := &tls.Config{}
+	if d.Config != nil {
+		config = d.Config.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, errors.New("invalid address format")
+		}
+		config.ServerName = host
+	}
+
+	base := d.Base
+	if base == nil {
+		base = &TCPDialer{}
+	}
+	raw, err := base.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(raw, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// TCPListenerFactory listens for TCP connections, the accepted sockets get
+// the options in Options
+type TCPListenerFactory struct {
+	Config  net.ListenConfig
+	Options sockopt.Options
+}
+
+func (f *TCPListenerFactory) Listen(ctx context.Context, address string) (net.Listener, error) {
+	config := listenConfigWithOptions(f.Config, f.Options)
+	listener, err := config.Listen(ctx, "tcp", address)
+	if err != nil || !f.Options.Nagle {
+		return listener, err
+	}
+	return &optionsListener{Listener: listener, options: f.Options}, nil
+}
+
+// UnixListenerFactory listens on a Unix domain socket, address is its path
+type UnixListenerFactory struct {
+	Config net.ListenConfig
+}
+
+func (f *UnixListenerFactory) Listen(ctx context.Context, address string) (net.Listener, error) {
+	return f.Config.Listen(ctx, "unix", address)
+}
+
+// TLSListenerFactory serves TLS over the listener of Base, TCP when nil.
+// Config must hold the server certificate.
+type TLSListenerFactory struct {
+	Base   ListenerFactory
+	Config *tls.Config
+}
+
+func (f *TLSListenerFactory) Listen(ctx context.Context, address string) (net.Listener, error) {
+	if f.Config == nil || (len(f.Config.Certificates) == 0 && f.Config.GetCertificate == nil) {
+		return nil, errors.New("TLS listener needs a certificate")
+	}
+
+	base := f.Base
+	if base == nil {
+		base = &TCPListenerFactory{}
+	}
+	listener, err := base.Listen(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, f.Config), nil
+}
+
+// ProxyListenerFactory expects a PROXY protocol header on every connection
+// of the listener of Base, TCP when nil. The connections then report the
+// original client address.
+type ProxyListenerFactory struct {
+	Base ListenerFactory
+
+	// HeaderTimeout bounds the wait for the header, see proxyproto.NewConn
+	HeaderTimeout time.Duration
+}
+
+func (f *ProxyListenerFactory) Listen(ctx context.Context, address string) (net.Listener, error) {
+	listener, err := orTCPListener(f.Base).Listen(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return proxyproto.NewListener(listener, f.HeaderTimeout), nil
+}
+
+// DefaultUDPIdleTimeout is how long a UDP peer may stay silent before its
+// connection is closed
+const DefaultUDPIdleTimeout = 2 * time.Minute
+
+// UDPListenerFactory turns a UDP socket into a listener with one connection
+// per remote address. A peer silent for IdleTimeout is closed,
+// DefaultUDPIdleTimeout when 0. A negative IdleTimeout keeps peers until
+// the listener closes.
+type UDPListenerFactory struct {
+	Config      net.ListenConfig
+	Options     sockopt.Options
+	IdleTimeout time.Duration
+}
+
+func (f *UDPListenerFactory) Listen(ctx context.Context, address string) (net.Listener, error) {
+	config := listenConfigWithOptions(f.Config, f.Options)
+	pc, err := config.ListenPacket(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	idle := f.IdleTimeout
+	if idle == 0 {
+		idle = DefaultUDPIdleTimeout
+	}
+	return NewPacketListener(pc, max(idle, 0)), nil
+}
@@ -0,0 +1,385 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/stats"
+	"github.com/gppmad/gonc/transfer"
+)
+
+// selfSigned returns a certificate for localhost and a pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// echo serves every connection of listener by writing back what it reads
+func echo(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// socksProxy is a minimal SOCKS5 server for CONNECT, with optional password
+// authentication. It records the last requested destination.
+func socksProxy(t *testing.T, username, password string) (string, chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socksHandshake(conn, username, password)
+				if err != nil {
+					return
+				}
+				targets <- target
+
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String(), targets
+}
+
+func socksHandshake(conn net.Conn, username, password string) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	methods := make([]byte, head[1])
+	io.ReadFull(conn, methods)
+
+	if username == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		auth := make([]byte, 2)
+		io.ReadFull(conn, auth)
+		user := make([]byte, auth[1])
+		io.ReadFull(conn, user)
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		pass := make([]byte, n[0])
+		io.ReadFull(conn, pass)
+		if string(user) != username || string(pass) != password {
+			conn.Write([]byte{1, 1})
+			return "", errors.New("bad credentials")
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return "", errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func TestTLSOverSOCKS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	factory := &TLSListenerFactory{Config: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	listener, err := factory.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go echo(listener)
+
+	proxy, targets := socksProxy(t, "", "")
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	dialer := &TLSDialer{
+		Base:   &SOCKS5Dialer{Proxy: proxy},
+		Config: &tls.Config{RootCAs: pool},
+	}
+	conn, err := dialer.Dial(context.Background(), net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The proxy resolves the name, the handshake checks it
+	if target := <-targets; target != net.JoinHostPort("localhost", port) {
+		t.Errorf("expected the proxy to receive the host name, got %s", target)
+	}
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("expected a TLS connection, got %T", conn)
+	}
+
+	conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Errorf("expected ping back, got %q, %v", reply, err)
+	}
+}
+
+func TestSOCKS5Authentication(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go echo(target)
+
+	proxy, _ := socksProxy(t, "user", "secret")
+
+	good := &SOCKS5Dialer{Proxy: proxy, Username: "user", Password: "secret"}
+	conn, err := good.Dial(context.Background(), target.Addr().String())
+	if err != nil {
+		t.Fatalf("dial with the right password failed: %v", err)
+	}
+	conn.Close()
+
+	bad := &SOCKS5Dialer{Proxy: proxy, Username: "user", Password: "wrong"}
+	if _, err := bad.Dial(context.Background(), target.Addr().String()); err == nil {
+		t.Error("expected the wrong password to be refused")
+	}
+}
+
+func TestPacketListenerDemux(t *testing.T) {
+	factory := &UDPListenerFactory{IdleTimeout: 100 * time.Millisecond}
+	listener, err := factory.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	clients := make([]net.Conn, 2)
+	for i := range clients {
+		if clients[i], err = net.Dial("udp", listener.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+		clients[i].Write([]byte{'a' + byte(i)})
+	}
+
+	// One connection per peer, answered through the right socket
+	for range clients {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		n, err := conn.Read(buf)
+		if err != nil || n != 1 {
+			t.Fatalf("unexpected read: %d, %v", n, err)
+		}
+		conn.Write([]byte{buf[0] - 'a' + 'A'})
+	}
+	for i, client := range clients {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 16)
+		n, err := client.Read(buf)
+		if want := string(rune('A' + i)); err != nil || string(buf[:n]) != want {
+			t.Errorf("client %d expected %q, got %q, %v", i, want, buf[:n], err)
+		}
+	}
+}
+
+func TestPacketListenerIdleTimeout(t *testing.T) {
+	factory := &UDPListenerFactory{IdleTimeout: 50 * time.Millisecond}
+	listener, err := factory.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("hello"))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected hello then EOF once idle, got %q, %v", data, err)
+	}
+}
+
+func TestUDPListenerIdleTimeoutDefault(t *testing.T) {
+	tests := map[time.Duration]time.Duration{
+		0:                DefaultUDPIdleTimeout,
+		time.Second:      time.Second,
+		-1 * time.Second: 0,
+	}
+	for configured, want := range tests {
+		listener, err := (&UDPListenerFactory{IdleTimeout: configured}).Listen(context.Background(), "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := listener.(*PacketListener).idleTimeout; got != want {
+			t.Errorf("IdleTimeout %v: expected peers to expire after %v, got %v", configured, want, got)
+		}
+		listener.Close()
+	}
+}
+
+func TestProxyProtocolBeneathTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	factory := &TLSListenerFactory{
+		Base:   &ProxyListenerFactory{},
+		Config: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	listener, err := factory.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	remotes := make(chan net.Addr, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remotes <- conn.RemoteAddr()
+		io.Copy(conn, conn)
+	}()
+
+	source := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+	dialer := &TLSDialer{
+		Base:   &ProxyHeaderDialer{Version: 2, Source: source},
+		Config: &tls.Config{RootCAs: pool, ServerName: "localhost"},
+	}
+	conn, err := dialer.Dial(context.Background(), listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-remotes; got.String() != source.String() {
+		t.Errorf("expected the server to see %v, got %v", source, got)
+	}
+	conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Errorf("expected ping back, got %q, %v", reply, err)
+	}
+}
+
+// A transfer reports its end to the statistics like a session does
+func TestSendFinishesStats(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dst := t.TempDir()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		transfer.Receive(conn, dst, nil)
+	}()
+
+	src := filepath.Join(t.TempDir(), "artifact.bin")
+	os.WriteFile(src, []byte("payload"), 0644)
+	config := ClientConfig{RemoteAddr: listener.Addr().String(), SendPath: src, Stats: stats.New()}
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Start(); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+
+	sum := config.Stats.Summary()
+	if sum.Closed.IsZero() || sum.CloseReason == "" {
+		t.Errorf("expected the transfer to finish the statistics, got %+v", sum)
+	}
+	if sum.BytesSent == 0 {
+		t.Error("expected the transfer traffic to be counted")
+	}
+}
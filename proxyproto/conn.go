@@ -0,0 +1,97 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its header
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Conn is a connection starting with a PROXY header. The header is read on
+// first use, after which RemoteAddr and LocalAddr report the original
+// addresses. Reads fail when the header is missing or invalid.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// NewConn reads the header of conn within timeout, DefaultHeaderTimeout when 0
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Header returns the header of the connection, reading it if needed
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = Read(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the original client address, or the address of the
+// proxy when the header does not carry one
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr returns the address of the proxy that sent the header
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// NetConn returns the connection from the proxy
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Listener wraps the connections of a listener in Conn
+type Listener struct {
+	net.Listener
+
+	// HeaderTimeout is passed to NewConn
+	HeaderTimeout time.Duration
+}
+
+// NewListener expects a PROXY header on every connection of l
+func NewListener(l net.Listener, headerTimeout time.Duration) *Listener {
+	return &Listener{Listener: l, HeaderTimeout: headerTimeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.HeaderTimeout), nil
+}
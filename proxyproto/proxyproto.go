@@ -0,0 +1,248 @@
+// Package proxyproto reads and writes the PROXY protocol headers load
+// balancers such as HAProxy put in front of a connection to pass on the
+// original client address, versions 1 (text) and 2 (binary)
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// signature starts every version 2 header
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Version 1 lines are at most 107 bytes including the CRLF
+const maxV1Length = 107
+
+// Version 2 commands and address families
+const (
+	cmdLocal = 0x0
+	cmdProxy = 0x1
+
+	famUnspec = 0x00
+	famTCP4   = 0x11
+	famUDP4   = 0x12
+	famTCP6   = 0x21
+	famUDP6   = 0x22
+	famUnix   = 0x31
+	famUnixDG = 0x32
+)
+
+// ErrNoHeader is returned when a connection does not start with a PROXY header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Header is a PROXY protocol header
+type Header struct {
+	// Version is 1 or 2
+	Version int
+
+	// Source and Destination are the original client and server addresses,
+	// nil when the proxy did not know them (UNKNOWN, LOCAL or Unix sockets)
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read reads a header of either version from r
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(r)
+	case '\r':
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Length {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrNoHeader
+	}
+	h := &Header{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v1 protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != (proto == "TCP4") {
+		return nil, fmt.Errorf("invalid %s address %q in PROXY header", proto, host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in PROXY header", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 parses the binary header: the signature, version and command,
+// family, length and the addresses, followed by TLVs that are skipped
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:12], signature) {
+		return nil, ErrNoHeader
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", fixed[12]>>4)
+	}
+	command, family := fixed[12]&0x0f, fixed[13]
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch command {
+	case cmdLocal:
+		return h, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	var size int
+	switch family {
+	case famTCP4, famUDP4:
+		size = 2*net.IPv4len + 4
+	case famTCP6, famUDP6:
+		size = 2*net.IPv6len + 4
+	case famUnspec, famUnix, famUnixDG:
+		return h, nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 address family %#x", family)
+	}
+	if len(payload) < size {
+		return nil, errors.New("PROXY v2 addresses truncated")
+	}
+
+	n := (size - 4) / 2
+	src, dst := net.IP(payload[:n]), net.IP(payload[n:2*n])
+	sport := int(binary.BigEndian.Uint16(payload[2*n:]))
+	dport := int(binary.BigEndian.Uint16(payload[2*n+2:]))
+	if family == famUDP4 || family == famUDP6 {
+		h.Source, h.Destination = &net.UDPAddr{IP: src, Port: sport}, &net.UDPAddr{IP: dst, Port: dport}
+	} else {
+		h.Source, h.Destination = &net.TCPAddr{IP: src, Port: sport}, &net.TCPAddr{IP: dst, Port: dport}
+	}
+	return h, nil
+}
+
+// Format encodes the header in its version. Without addresses it is an
+// UNKNOWN (v1) or LOCAL (v2) header.
+func (h *Header) Format() ([]byte, error) {
+	srcIP, srcPort, udp, err := splitAddr(h.Source)
+	if err != nil {
+		return nil, err
+	}
+	dstIP, dstPort, _, err := splitAddr(h.Destination)
+	if err != nil {
+		return nil, err
+	}
+	known := srcIP != nil && dstIP != nil
+	if known && (srcIP.To4() == nil) != (dstIP.To4() == nil) {
+		return nil, errors.New("PROXY header addresses must be of the same family")
+	}
+	v4 := known && srcIP.To4() != nil
+
+	switch h.Version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		if udp {
+			return nil, errors.New("PROXY v1 headers cannot describe UDP")
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcPort, dstPort)), nil
+
+	case 2:
+		buf := append([]byte(nil), signature...)
+		if !known {
+			return append(buf, 0x20|cmdLocal, famUnspec, 0, 0), nil
+		}
+
+		family, ipLen := byte(famTCP6), net.IPv6len
+		if v4 {
+			family, ipLen = famTCP4, net.IPv4len
+		}
+		if udp {
+			family++
+		}
+		buf = append(buf, 0x20|cmdProxy, family)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(2*ipLen+4))
+		if v4 {
+			buf = append(buf, srcIP.To4()...)
+			buf = append(buf, dstIP.To4()...)
+		} else {
+			buf = append(buf, srcIP.To16()...)
+			buf = append(buf, dstIP.To16()...)
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(srcPort))
+		return binary.BigEndian.AppendUint16(buf, uint16(dstPort)), nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+}
+
+// splitAddr returns the IP and port of a TCP or UDP address, nil for any
+// other address
+func splitAddr(addr net.Addr) (ip net.IP, port int, udp bool, err error) {
+	switch a := addr.(type) {
+	case nil:
+		return nil, 0, false, nil
+	case *net.TCPAddr:
+		return a.IP, a.Port, false, nil
+	case *net.UDPAddr:
+		return a.IP, a.Port, true, nil
+	}
+	return nil, 0, false, fmt.Errorf("PROXY headers cannot carry %s addresses", addr.Network())
+}
@@ -0,0 +1,160 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   Header
+		expected string // the v1 encoding, empty for v2
+	}{
+		{"v1 IPv4", Header{Version: 1, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("192.0.2.2:443")}, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"},
+		{"v1 IPv6", Header{Version: 1, Source: tcpAddr("[2001:db8::1]:1000"), Destination: tcpAddr("[2001:db8::2]:80")}, "PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n"},
+		{"v1 unknown", Header{Version: 1}, "PROXY UNKNOWN\r\n"},
+		{"v2 IPv4", Header{Version: 2, Source: tcpAddr("192.0.2.1:56324"), Destination: tcpAddr("192.0.2.2:443")}, ""},
+		{"v2 IPv6", Header{Version: 2, Source: tcpAddr("[2001:db8::1]:1000"), Destination: tcpAddr("[2001:db8::2]:80")}, ""},
+		{"v2 UDP", Header{Version: 2, Source: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}, Destination: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 53}}, ""},
+		{"v2 local", Header{Version: 2}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.header.Format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expected != "" && string(encoded) != tt.expected {
+				t.Errorf("Format() = %q, want %q", encoded, tt.expected)
+			}
+
+			// The payload following the header must stay untouched
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(encoded), strings.NewReader("payload")))
+			got, err := Read(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != tt.header.Version || !sameAddr(got.Source, tt.header.Source) || !sameAddr(got.Destination, tt.header.Destination) {
+				t.Errorf("Read() = %+v, want %+v", got, tt.header)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("expected the payload after the header, got %q", rest)
+			}
+		})
+	}
+}
+
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
+
+func TestReadV2SkipsTLVs(t *testing.T) {
+	h := Header{Version: 2, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("192.0.2.2:2")}
+	encoded, err := h.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Append a NOOP TLV and grow the length accordingly
+	tlv := []byte{0x04, 0x00, 0x02, 0xaa, 0xbb}
+	encoded = append(encoded, tlv...)
+	encoded[15] += byte(len(tlv))
+
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(encoded), strings.NewReader("x")))
+	got, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Source.String() != "192.0.2.1:1" {
+		t.Errorf("unexpected source %v", got.Source)
+	}
+	if b, _ := r.ReadByte(); b != 'x' {
+		t.Errorf("expected the TLVs to be skipped, next byte is %q", b)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, input := range []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 1\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 1 99999\r\n",
+		"PROXY SCTP 192.0.2.1 192.0.2.2 1 2\r\n",
+		"PROXY " + strings.Repeat("A", 200) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x04abcd",
+	} {
+		if _, err := Read(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("Read(%q) should fail", input)
+		}
+	}
+
+	_, err := Read(bufio.NewReader(strings.NewReader("hello")))
+	if !errors.Is(err, ErrNoHeader) {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+func TestFormatMixedFamilies(t *testing.T) {
+	h := Header{Version: 1, Source: tcpAddr("192.0.2.1:1"), Destination: tcpAddr("[2001:db8::2]:2")}
+	if _, err := h.Format(); err == nil {
+		t.Error("expected an error for addresses of different families")
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Write([]byte("PROXY TCP4 198.51.100.7 203.0.113.1 40000 8080\r\nhello"))
+	}()
+
+	conn := NewConn(server, 0)
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != "198.51.100.7:40000" {
+		t.Errorf("RemoteAddr() = %s, want the original client", got)
+	}
+	if got := conn.LocalAddr().String(); got != "203.0.113.1:8080" {
+		t.Errorf("LocalAddr() = %s, want the original destination", got)
+	}
+	if conn.ProxyAddr() != server.RemoteAddr() {
+		t.Error("ProxyAddr() should be the address of the proxy")
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("expected the payload, got %q, %v", buf, err)
+	}
+}
+
+func TestConnWithoutHeader(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("hello"))
+
+	conn := NewConn(server, 0)
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 5)); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+	if conn.RemoteAddr() != server.RemoteAddr() {
+		t.Error("expected the real address without a header")
+	}
+}
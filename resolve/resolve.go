@@ -0,0 +1,100 @@
+// Package resolve turns the host names of client connections into
+// addresses, with static overrides, a numeric only mode and an optional
+// DNS server replacing the system configuration
+package resolve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNumeric is returned for host names when lookups are disabled
+var ErrNumeric = errors.New("host names cannot be resolved in numeric mode")
+
+// Resolver looks up host names. The zero value, like a nil *Resolver, uses
+// the system resolver.
+type Resolver struct {
+	// Numeric refuses to look up host names, overrides still apply
+	Numeric bool
+
+	// Overrides maps "host:port" to the addresses used instead of a lookup,
+	// see ParseOverride
+	Overrides map[string][]net.IP
+
+	// Server is the address of the DNS server to query instead of the
+	// system configuration, port 53 when missing
+	Server string
+}
+
+// LookupIPAddr returns the addresses of host for a connection to port. IP
+// literals are returned as they are.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host, port string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if r == nil {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	if ips, ok := r.Overrides[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: ip}
+		}
+		return addrs, nil
+	}
+	if r.Numeric {
+		return nil, fmt.Errorf("lookup %s: %w", host, ErrNumeric)
+	}
+	return r.resolver().LookupIPAddr(ctx, host)
+}
+
+// resolver returns the net.Resolver querying Server
+func (r *Resolver) resolver() *net.Resolver {
+	if r.Server == "" {
+		return net.DefaultResolver
+	}
+
+	server := r.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// AddOverride parses an override in the curl --resolve format,
+// HOST:PORT:ADDR[,ADDR...], and adds it to the resolver. IPv6 addresses
+// may be written in brackets.
+func (r *Resolver) AddOverride(spec string) error {
+	host, rest, ok := strings.Cut(spec, ":")
+	port, list, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || host == "" || port == "" || list == "" {
+		return fmt.Errorf("invalid override %q, expected HOST:PORT:ADDR", spec)
+	}
+
+	var ips []net.IP
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(addr), "["), "]")
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("invalid override %q: %q is not an IP address", spec, addr)
+		}
+		ips = append(ips, ip)
+	}
+
+	if r.Overrides == nil {
+		r.Overrides = make(map[string][]net.IP)
+	}
+	key := net.JoinHostPort(strings.ToLower(host), port)
+	r.Overrides[key] = append(r.Overrides[key], ips...)
+	return nil
+}
@@ -0,0 +1,96 @@
+package resolve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestOverride(t *testing.T) {
+	var r Resolver
+	if err := r.AddOverride("Example.com:443:10.0.0.7,[2001:db8::1]"); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := r.LookupIPAddr(context.Background(), "example.com", "443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || !addrs[0].IP.Equal(net.ParseIP("10.0.0.7")) || !addrs[1].IP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected addresses %v", addrs)
+	}
+}
+
+func TestOverrideOnlyMatchesItsPort(t *testing.T) {
+	r := Resolver{Numeric: true}
+	if err := r.AddOverride("example.com:443:10.0.0.7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.LookupIPAddr(context.Background(), "example.com", "80"); err == nil {
+		t.Error("expected the lookup for another port to be refused in numeric mode")
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	r := &Resolver{Numeric: true}
+
+	addrs, err := r.LookupIPAddr(context.Background(), "192.0.2.1", "80")
+	if err != nil || len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("IP literals should pass through, got %v, %v", addrs, err)
+	}
+
+	_, err = r.LookupIPAddr(context.Background(), "example.com", "80")
+	if !errors.Is(err, ErrNumeric) {
+		t.Errorf("expected example.com to be refused, got %v", err)
+	}
+}
+
+func TestAddOverrideInvalid(t *testing.T) {
+	for _, spec := range []string{"example.com", "example.com:443", "example.com:443:", ":443:10.0.0.1", "example.com:443:nope"} {
+		var r Resolver
+		if err := r.AddOverride(spec); err == nil {
+			t.Errorf("AddOverride(%q) should fail", spec)
+		}
+	}
+}
+
+// The custom server is queried instead of the system configuration
+func TestServer(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer pc.Close()
+
+	queried := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case queried <- struct{}{}:
+			default:
+			}
+			// Answer with the query flagged as a name error (NXDOMAIN)
+			reply := append([]byte(nil), buf[:n]...)
+			reply[2] |= 0x80
+			reply[3] = reply[3]&0xf0 | 3
+			pc.WriteTo(reply, addr)
+		}
+	}()
+
+	r := &Resolver{Server: pc.LocalAddr().String()}
+	if _, err := r.LookupIPAddr(context.Background(), "gonc.invalid", "80"); err == nil {
+		t.Error("expected the fake server to answer NXDOMAIN")
+	}
+	select {
+	case <-queried:
+	default:
+		t.Error("the custom DNS server was not queried")
+	}
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/stats"
+)
+
+// Direction tells which half of a session failed
+type Direction int
+
+const (
+	Send    Direction = iota // From the input to the connection
+	Receive                  // From the connection to the output
+)
+
+func (d Direction) String() string {
+	if d == Send {
+		return "send"
+	}
+	return "receive"
+}
+
+// Error is a failure of one direction of a session
+type Error struct {
+	Direction Direction
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Direction == Send {
+		return fmt.Sprintf("error writing in the connection: %v", e.Err)
+	}
+	return fmt.Sprintf("error reading from the connection: %v", e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Session copies Input to Conn and Conn to Output until both directions are
+// done. It works the same for every transport, TCP, TLS or anything else
+// behind a net.Conn.
+type Session struct {
+	Input  io.Reader
+	Output io.Writer
+	Conn   net.Conn
+
+	// Stats, when set, counts the traffic of the session
+	Stats *stats.Stats
+
+	// Logger receives session events, nil discards them
+	Logger *slog.Logger
+}
+
+// New creates a session over conn, nil input and output mean stdin and stdout
+func New(conn net.Conn, input io.Reader, output io.Writer) *Session {
+	if input == nil {
+		input = os.Stdin
+	}
+
+	if output == nil {
+		output = os.Stdout
+	}
+
+	return &Session{Conn: conn, Input: input, Output: output}
+}
+
+// Start runs the session until the input ends and the peer closes the connection
+func (s *Session) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext is like Start but ends the session, closing the connection,
+// when ctx is done
+func (s *Session) StartContext(ctx context.Context) error {
+	if s.Conn == nil {
+		return errors.New("connect to the target before initialize a new connection")
+	}
+
+	logger := logging.OrDiscard(s.Logger).With(logging.RemoteAddr(s.Conn))
+	logger.Debug("session started")
+
+	conn, input := s.Conn, s.Input
+	if s.Stats != nil {
+		conn, input = s.Stats.Conn(conn), s.Stats.Input(input)
+	}
+
+	err := Copy(ctx, conn, input, s.Output)
+	if s.Stats != nil {
+		s.Stats.Finish(err)
+	}
+
+	if err != nil {
+		logger.Info("session ended", "error", err)
+	} else {
+		logger.Info("session ended")
+	}
+	return err
+}
+
+// Close the connection
+func (s *Session) Close() error {
+	logging.OrDiscard(s.Logger).Debug("closing connection", logging.RemoteAddr(s.Conn))
+	return s.Conn.Close()
+}
+
+// Copy pipes input to conn and conn to output. It returns once the input is
+// exhausted and the connection reached EOF, as soon as the connection ends
+// even when a read of the input is still pending, on the first failure as
+// an *Error, or when ctx is done, closing conn. A pending input read is
+// left behind, its data is dropped.
+func Copy(ctx context.Context, conn io.ReadWriteCloser, input io.Reader, output io.Writer) error {
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(output, conn)
+		received <- err
+	}()
+
+	// The input may block, so it is read on its own too
+	sent := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, input)
+		sent <- err
+	}()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			return &Error{Direction: Send, Err: err}
+		}
+	case err := <-received:
+		// The peer is gone, an interactive input may never return from
+		// its read
+		if err != nil {
+			return &Error{Direction: Receive, Err: err}
+		}
+		// An input failure that came in at the same time is still reported
+		select {
+		case err := <-sent:
+			if err != nil {
+				return &Error{Direction: Send, Err: err}
+			}
+		default:
+		}
+		return nil
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-received:
+		if err != nil {
+			return &Error{Direction: Receive, Err: err}
+		}
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader always fails with err
+type failingReader struct{ err error }
+
+func (r failingReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// failingWriter always fails with err
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestCopyBothDirections(t *testing.T) {
+	local, remote := net.Pipe()
+
+	// The peer echoes in upper case and hangs up once the input is done
+	go func() {
+		data, _ := io.ReadAll(io.LimitReader(remote, 5))
+		remote.Write(bytes.ToUpper(data))
+		remote.Close()
+	}()
+
+	output := new(bytes.Buffer)
+	if err := Copy(context.Background(), local, strings.NewReader("hello"), output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if output.String() != "HELLO" {
+		t.Errorf("expected HELLO, got %q", output.String())
+	}
+}
+
+// A remote shell exiting ends the session, even when the user types nothing
+func TestCopyRemoteCloseWithOpenInput(t *testing.T) {
+	local, remote := net.Pipe()
+	go func() {
+		remote.Write([]byte("bye"))
+		remote.Close()
+	}()
+
+	// Never written nor closed, like a terminal waiting for a key
+	input, stdin := io.Pipe()
+	defer stdin.Close()
+
+	output := new(bytes.Buffer)
+	done := make(chan error, 1)
+	go func() { done <- Copy(context.Background(), local, input, output) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Copy kept waiting on the input after the remote closed")
+	}
+	if output.String() != "bye" {
+		t.Errorf("expected bye, got %q", output.String())
+	}
+}
+
+func TestCopyErrorDirection(t *testing.T) {
+	cause := errors.New("boom")
+
+	t.Run("send", func(t *testing.T) {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		go io.Copy(io.Discard, remote)
+
+		err := Copy(context.Background(), local, failingReader{cause}, io.Discard)
+		var serr *Error
+		if !errors.As(err, &serr) || serr.Direction != Send || !errors.Is(err, cause) {
+			t.Fatalf("expected a send error wrapping %v, got %v", cause, err)
+		}
+		if !strings.Contains(err.Error(), "error writing in the connection") {
+			t.Errorf("unexpected message %q", err.Error())
+		}
+	})
+
+	t.Run("receive", func(t *testing.T) {
+		local, remote := net.Pipe()
+		go remote.Write([]byte("data"))
+
+		err := Copy(context.Background(), local, strings.NewReader(""), failingWriter{cause})
+		var serr *Error
+		if !errors.As(err, &serr) || serr.Direction != Receive || !errors.Is(err, cause) {
+			t.Fatalf("expected a receive error wrapping %v, got %v", cause, err)
+		}
+		if !strings.Contains(err.Error(), "error reading from the connection") {
+			t.Errorf("unexpected message %q", err.Error())
+		}
+	})
+}
+
+func TestStartWithoutConn(t *testing.T) {
+	if err := New(nil, strings.NewReader(""), io.Discard).Start(); err == nil {
+		t.Error("expected an error without a connection")
+	}
+}
@@ -0,0 +1,438 @@
+package shaping
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock is the time source used for shaping, replaceable in tests
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Options describes the shaping applied to one direction of a stream.
+// The zero value leaves the stream untouched.
+type Options struct {
+	Rate      int64         // Bytes per second, 0 means unlimited
+	Latency   time.Duration // Delay added to the stream, chunks in flight overlap
+	Jitter    time.Duration // Random variation of the latency, in both directions
+	ChunkSize int           // Largest piece moved at once, 0 picks one from Rate
+	Clock     Clock         // Defaults to the real clock
+}
+
+// Enabled reports whether the options change the stream at all
+func (o Options) Enabled() bool {
+	return o.Rate > 0 || o.Latency > 0 || o.Jitter > 0 || o.ChunkSize > 0
+}
+
+func (o Options) clock() Clock {
+	if o.Clock == nil {
+		return realClock{}
+	}
+	return o.Clock
+}
+
+// chunkSize returns the size of the pieces a stream is cut into: the
+// configured one, or a tenth of a second worth of data when rate limited
+func (o Options) chunkSize() int {
+	if o.ChunkSize > 0 {
+		return o.ChunkSize
+	}
+	if o.Rate > 0 {
+		return int(max(o.Rate/10, 1))
+	}
+	return 0
+}
+
+// delay returns the latency for the next chunk, jitter included
+func (o Options) delay() time.Duration {
+	d := o.Latency
+	if o.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*o.Jitter)+1)) - o.Jitter
+	}
+	return max(d, 0)
+}
+
+// ParseRate parses a rate such as 512, 64K, 1M or 2G bytes per second.
+// Suffixes are powers of 1024.
+func ParseRate(rate string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(rate))
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected a positive number with an optional K, M or G suffix", rate)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("rate %q is too large", rate)
+	}
+	return n * multiplier, nil
+}
+
+// TokenBucket limits a byte stream to a rate with bursts up to its capacity
+type TokenBucket struct {
+	mu     sync.Mutex
+	clock  Clock
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket refilled at rate tokens per second
+func NewTokenBucket(rate int64, burst int, clock Clock) *TokenBucket {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &TokenBucket{
+		clock:  clock,
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// Wait blocks until n tokens are available and takes them. Tokens are
+// reserved before sleeping, so concurrent callers queue up fairly.
+func (b *TokenBucket) Wait(n int) {
+	b.mu.Lock()
+	now := b.clock.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		b.clock.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// queueLen bounds the chunks held back by a latency in one direction. Like
+// a window it caps the throughput at queueLen chunks per latency.
+const queueLen = 256
+
+// readAhead is the chunk size read ahead when neither ChunkSize nor Rate
+// sets one
+const readAhead = 32 << 10
+
+// shaper applies Options to the chunks of one direction
+type shaper struct {
+	opts   Options
+	chunk  int
+	bucket *TokenBucket
+	last   time.Time // release time of the previous chunk
+}
+
+func newShaper(opts Options) *shaper {
+	s := &shaper{opts: opts, chunk: opts.chunkSize()}
+	if opts.Rate > 0 {
+		s.bucket = NewTokenBucket(opts.Rate, s.chunk, opts.clock())
+	}
+	return s
+}
+
+// limit returns the largest piece of n bytes to move at once
+func (s *shaper) limit(n int) int {
+	if s.chunk > 0 && n > s.chunk {
+		return s.chunk
+	}
+	return n
+}
+
+// delays reports whether chunks are held back by a latency
+func (s *shaper) delays() bool {
+	return s.opts.Latency > 0 || s.opts.Jitter > 0
+}
+
+// throttle waits until the rate allows a chunk of n bytes
+func (s *shaper) throttle(n int) {
+	if s.bucket != nil {
+		s.bucket.Wait(n)
+	}
+}
+
+// release returns when a chunk arriving now is delivered, never before the
+// previous one so the stream keeps its order
+func (s *shaper) release() time.Time {
+	at := s.opts.clock().Now().Add(s.opts.delay())
+	if at.Before(s.last) {
+		at = s.last
+	}
+	s.last = at
+	return at
+}
+
+// sleepUntil waits for the release time of a chunk
+func (s *shaper) sleepUntil(at time.Time) {
+	clock := s.opts.clock()
+	if d := at.Sub(clock.Now()); d > 0 {
+		clock.Sleep(d)
+	}
+}
+
+// delayed is a chunk waiting for its release time
+type delayed struct {
+	data []byte
+	at   time.Time
+	err  error
+}
+
+// Reader shapes the data read from an io.Reader. With a latency it reads
+// ahead, so the chunks are delayed together instead of one after another.
+type Reader struct {
+	r io.Reader
+	s *shaper
+
+	start   sync.Once
+	stop    sync.Once
+	queue   chan delayed
+	done    chan struct{}
+	pending []byte
+	err     error
+}
+
+// NewReader returns a Reader delivering r according to opts
+func NewReader(r io.Reader, opts Options) *Reader {
+	return &Reader{r: r, s: newShaper(opts), done: make(chan struct{})}
+}
+
+// Read reads at most one chunk and delays it
+func (r *Reader) Read(p []byte) (int, error) {
+	if !r.s.delays() {
+		n, err := r.r.Read(p[:r.s.limit(len(p))])
+		if n > 0 {
+			r.s.throttle(n)
+		}
+		return n, err
+	}
+
+	r.startReading()
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		select {
+		case d := <-r.queue:
+			r.s.sleepUntil(d.at)
+			r.pending, r.err = d.data, d.err
+		case <-r.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// startReading starts reading ahead on the first call
+func (r *Reader) startReading() {
+	r.start.Do(func() {
+		r.queue = make(chan delayed, queueLen)
+		go r.readAhead()
+	})
+}
+
+// readAhead reads r as fast as the rate allows and stamps every chunk with
+// its release time, until r fails or the Reader is closed
+func (r *Reader) readAhead() {
+	size := r.s.chunk
+	if size == 0 {
+		size = readAhead
+	}
+	for {
+		buf := make([]byte, size)
+		n, err := r.r.Read(buf)
+		if n > 0 {
+			r.s.throttle(n)
+		}
+		select {
+		case r.queue <- delayed{data: buf[:n], at: r.s.release(), err: err}:
+		case <-r.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close stops reading ahead and makes Read return io.ErrClosedPipe, it does
+// not close the underlying reader
+func (r *Reader) Close() error {
+	r.stop.Do(func() { close(r.done) })
+	return nil
+}
+
+// Writer shapes the data written to an io.Writer. With a latency, Write
+// queues the chunks and returns, they are written once their delay passed
+// and a write error is returned by the next Write or Close.
+type Writer struct {
+	w io.Writer
+	s *shaper
+
+	mu      sync.Mutex // serializes Write and Close
+	start   sync.Once
+	queue   chan delayed
+	flushed chan struct{}
+	closed  bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// NewWriter returns a Writer sending to w according to opts
+func NewWriter(w io.Writer, opts Options) *Writer {
+	return &Writer{w: w, s: newShaper(opts), flushed: make(chan struct{})}
+}
+
+// Write splits p into chunks, each one delayed before being written
+func (w *Writer) Write(p []byte) (int, error) {
+	if !w.s.delays() {
+		written := 0
+		for written < len(p) {
+			chunk := p[written : written+w.s.limit(len(p)-written)]
+			w.s.throttle(len(chunk))
+
+			n, err := w.w.Write(chunk)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		return written, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	w.start.Do(func() {
+		w.queue = make(chan delayed, queueLen)
+		go w.send()
+	})
+
+	for written := 0; written < len(p); {
+		if err := w.failed(); err != nil {
+			return written, err
+		}
+		chunk := p[written : written+w.s.limit(len(p)-written)]
+		w.s.throttle(len(chunk))
+		// p belongs to the caller once Write returns
+		w.queue <- delayed{data: append([]byte(nil), chunk...), at: w.s.release()}
+		written += len(chunk)
+	}
+	return len(p), nil
+}
+
+// send writes every queued chunk at its release time. After a failure the
+// rest is discarded so Write never blocks on a full queue.
+func (w *Writer) send() {
+	defer close(w.flushed)
+	for d := range w.queue {
+		if w.failed() != nil {
+			continue
+		}
+		w.s.sleepUntil(d.at)
+		if _, err := w.w.Write(d.data); err != nil {
+			w.errMu.Lock()
+			w.err = err
+			w.errMu.Unlock()
+		}
+	}
+}
+
+func (w *Writer) failed() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+// Close writes the chunks still delayed and returns the first write error.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		if w.queue != nil {
+			close(w.queue)
+			<-w.flushed
+		}
+	}
+	return w.failed()
+}
+
+// Conn shapes both directions of a net.Conn
+type Conn struct {
+	net.Conn
+	r *Reader
+	w *Writer
+}
+
+// NewConn shapes the data sent on conn with send and the data received with recv
+func NewConn(conn net.Conn, send, recv Options) *Conn {
+	return &Conn{Conn: conn, r: NewReader(conn, recv), w: NewWriter(conn, send)}
+}
+
+// Read receives shaped data
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write sends shaped data
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// Close writes the data still delayed, then closes the connection. A peer
+// not reading holds it up for at most the latency and a second.
+func (c *Conn) Close() error {
+	forced := make(chan error, 1)
+	force := time.AfterFunc(c.w.s.opts.Latency+c.w.s.opts.Jitter+time.Second, func() { forced <- c.Conn.Close() })
+
+	c.w.Close()
+	c.r.Close()
+	if !force.Stop() {
+		return <-forced
+	}
+	return c.Conn.Close()
+}
+
+// NetConn returns the shaped connection
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Handler wraps a server connection handler so every accepted connection is shaped
+func Handler(send, recv Options, next func(conn net.Conn, input io.Reader, output io.Writer) error) func(conn net.Conn, input io.Reader, output io.Writer) error {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		return next(NewConn(conn, send, recv), input, output)
+	}
+}
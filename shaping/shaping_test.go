@@ -0,0 +1,276 @@
+package shaping
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves forward when something sleeps on it. A non nil hold
+// makes Sleep wait until it is closed.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+	hold   chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if c.hold != nil {
+		<-c.hold
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+}
+
+func (c *fakeClock) elapsed() time.Duration {
+	return c.Now().Sub(time.Unix(0, 0))
+}
+
+func (c *fakeClock) slept() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+// readAheadFully starts r reading ahead and waits until chunks are queued,
+// so they are all stamped before the clock moves
+func readAheadFully(t *testing.T, r *Reader, chunks int) {
+	t.Helper()
+	r.startReading()
+	deadline := time.Now().Add(time.Second)
+	for len(r.queue) < chunks {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d chunks read ahead, got %d", chunks, len(r.queue))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]int64{
+		"512": 512,
+		"64K": 64 << 10,
+		"1M":  1 << 20,
+		"2g":  2 << 30,
+	}
+	for input, want := range tests {
+		got, err := ParseRate(input)
+		if err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "fast", "-1M", "0", "1T", "9223372036854775807K", "8589934592G"} {
+		if _, err := ParseRate(input); err == nil {
+			t.Errorf("ParseRate(%q) should fail", input)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucket(1000, 100, clock)
+
+	// The bucket starts full, the first burst is free
+	bucket.Wait(100)
+	if clock.elapsed() != 0 {
+		t.Fatalf("expected no wait for the initial burst, waited %v", clock.elapsed())
+	}
+
+	// Then every 100 bytes cost 100ms at 1000 B/s
+	bucket.Wait(100)
+	bucket.Wait(100)
+	if want := 200 * time.Millisecond; clock.elapsed() != want {
+		t.Fatalf("expected to wait %v, waited %v", want, clock.elapsed())
+	}
+}
+
+func TestWriterRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	out := new(bytes.Buffer)
+	w := NewWriter(out, Options{Rate: 1000, Clock: clock})
+
+	payload := bytes.Repeat([]byte("x"), 3000)
+	n, err := w.Write(payload)
+	if err != nil || n != len(payload) {
+		t.Fatalf("unexpected write result: %d, %v", n, err)
+	}
+
+	if !bytes.Equal(out.Bytes(), payload) {
+		t.Fatal("payload altered by the writer")
+	}
+
+	// 3000 bytes at 1000 B/s, minus the initial 100 byte burst
+	if want := 2900 * time.Millisecond; clock.elapsed() != want {
+		t.Fatalf("expected the write to take %v, took %v", want, clock.elapsed())
+	}
+	// Chunks default to a tenth of a second of data
+	if len(clock.sleeps) != 29 {
+		t.Errorf("expected 29 delayed chunks, got %d", len(clock.sleeps))
+	}
+}
+
+func TestReaderLatencyAndChunks(t *testing.T) {
+	clock := newFakeClock()
+	src := strings.NewReader("abcdefghij")
+	r := NewReader(src, Options{Latency: 50 * time.Millisecond, ChunkSize: 4, Clock: clock})
+	// 10 bytes in chunks of 4: three chunks and the end of the stream
+	readAheadFully(t, r, 4)
+
+	var reads []string
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if strings.Join(reads, "|") != "abcd|efgh|ij" {
+		t.Fatalf("unexpected chunks %q", reads)
+	}
+	if want := 50 * time.Millisecond; clock.elapsed() != want {
+		t.Errorf("expected the read to take %v, took %v", want, clock.elapsed())
+	}
+}
+
+// Latency delays the stream, chunks arriving together are released
+// together instead of one latency after another
+func TestLatencyOverlapsChunks(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	payload := bytes.Repeat([]byte("x"), 20)
+
+	clock := newFakeClock()
+	r := NewReader(bytes.NewReader(payload), Options{Latency: latency, ChunkSize: 1, Clock: clock})
+	readAheadFully(t, r, len(payload)+1)
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("unexpected read result: %q, %v", got, err)
+	}
+	if sleeps := clock.slept(); len(sleeps) != 1 || sleeps[0] != latency {
+		t.Errorf("expected a single wait of %v, got %v", latency, sleeps)
+	}
+
+	// The writes are all queued before the delayed chunks are sent
+	clock = newFakeClock()
+	clock.hold = make(chan struct{})
+	out := new(bytes.Buffer)
+	w := NewWriter(out, Options{Latency: latency, ChunkSize: 1, Clock: clock})
+	for i := range payload {
+		if _, err := w.Write(payload[i : i+1]); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+	}
+	close(clock.hold)
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	if sleeps := clock.slept(); len(sleeps) != 1 || sleeps[0] != latency {
+		t.Errorf("expected a single wait of %v, got %v", latency, sleeps)
+	}
+	if !bytes.Equal(out.Bytes(), payload) {
+		t.Errorf("payload altered by the writer: %q", out.Bytes())
+	}
+}
+
+func TestReadAfterClose(t *testing.T) {
+	src, feed := io.Pipe()
+	defer feed.Close()
+	r := NewReader(src, Options{Latency: time.Millisecond})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 8))
+		result <- err
+	}()
+	r.Close()
+
+	select {
+	case err := <-result:
+		if err != io.ErrClosedPipe {
+			t.Errorf("expected io.ErrClosedPipe, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read still blocked after Close")
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestDelayedWriteError(t *testing.T) {
+	w := NewWriter(failingWriter{}, Options{Latency: time.Millisecond})
+	if _, err := w.Write([]byte("lost")); err != nil {
+		t.Fatalf("the first write is only queued, got %v", err)
+	}
+	if err := w.Close(); err != io.ErrShortWrite {
+		t.Errorf("expected the delayed write error from Close, got %v", err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("expected an error writing after Close")
+	}
+}
+
+func TestJitterStaysInRange(t *testing.T) {
+	clock := newFakeClock()
+	s := newShaper(Options{Latency: 100 * time.Millisecond, Jitter: 20 * time.Millisecond, Clock: clock})
+
+	for i := 0; i < 200; i++ {
+		// Chunks far apart are never held back by the previous one
+		clock.Sleep(time.Second)
+		if d := s.release().Sub(clock.Now()); d < 80*time.Millisecond || d > 120*time.Millisecond {
+			t.Fatalf("delay %v outside latency ± jitter", d)
+		}
+	}
+}
+
+// Jitter must not reorder chunks arriving close together
+func TestReleaseKeepsOrder(t *testing.T) {
+	clock := newFakeClock()
+	s := newShaper(Options{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond, Clock: clock})
+
+	last := s.release()
+	for i := 0; i < 200; i++ {
+		clock.Sleep(time.Millisecond)
+		at := s.release()
+		if at.Before(last) {
+			t.Fatalf("chunk %d released at %v, before the previous one at %v", i, at, last)
+		}
+		last = at
+	}
+}
+
+func TestOptionsEnabled(t *testing.T) {
+	if (Options{}).Enabled() {
+		t.Error("zero options should not shape anything")
+	}
+	if !(Options{Rate: 1}).Enabled() {
+		t.Error("a rate should enable shaping")
+	}
+}
@@ -0,0 +1,24 @@
+package sockopt
+
+import (
+	"os"
+	"syscall"
+)
+
+// BindToDevice returns a control binding the socket to a network device,
+// such as eth0, with SO_BINDTODEVICE. Traffic then only flows through it
+// whatever the routing table says.
+func BindToDevice(device string) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+		}
+		return nil
+	}
+}
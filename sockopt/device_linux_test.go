@@ -0,0 +1,34 @@
+package sockopt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestBindToDevice(t *testing.T) {
+	config := net.ListenConfig{Control: BindToDevice("lo")}
+	listener, err := config.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("binding to a device needs CAP_NET_RAW")
+	}
+	if err != nil {
+		t.Fatalf("cannot listen on lo: %v", err)
+	}
+	defer listener.Close()
+
+	// Traffic over lo still reaches the listener
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot connect through lo: %v", err)
+	}
+	conn.Close()
+
+	bad := net.ListenConfig{Control: BindToDevice("no-such-device0")}
+	if l, err := bad.Listen(context.Background(), "tcp", "127.0.0.1:0"); err == nil {
+		l.Close()
+		t.Error("expected an error for a missing device")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sockopt
+
+import "syscall"
+
+// BindToDevice is only available on Linux
+func BindToDevice(device string) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		return ErrNotSupported
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !unix
+
+package sockopt
+
+import (
+	"net"
+	"syscall"
+)
+
+// MulticastTTL is not supported on this platform
+func MulticastTTL(ttl int) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		return ErrNotSupported
+	}
+}
+
+// MulticastLoopback is not supported on this platform
+func MulticastLoopback(on bool) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		return ErrNotSupported
+	}
+}
+
+// MulticastInterface is not supported on this platform
+func MulticastInterface(iface *net.Interface) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		return ErrNotSupported
+	}
+}
+
+// Broadcast is not supported on this platform
+func Broadcast(network, address string, c syscall.RawConn) error {
+	return ErrNotSupported
+}
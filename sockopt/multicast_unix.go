@@ -0,0 +1,86 @@
+//go:build unix
+
+package sockopt
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// isIPv6 reports whether the socket of network is an IPv6 one
+func isIPv6(network string) bool {
+	return network == "udp6" || network == "tcp6" || network == "ip6"
+}
+
+// MulticastTTL sets how many hops multicast datagrams may travel, 1 keeps
+// them on the local network
+func MulticastTTL(ttl int) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		if isIPv6(network) {
+			return setOpt(c, "IPV6_MULTICAST_HOPS", syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ttl)
+		}
+		return setOpt(c, "IP_MULTICAST_TTL", syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	}
+}
+
+// MulticastLoopback sets whether multicast datagrams sent from the socket
+// are delivered to listeners on the same host
+func MulticastLoopback(on bool) Control {
+	value := 0
+	if on {
+		value = 1
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if isIPv6(network) {
+			return setOpt(c, "IPV6_MULTICAST_LOOP", syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, value)
+		}
+		return setOpt(c, "IP_MULTICAST_LOOP", syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, value)
+	}
+}
+
+// MulticastInterface sends multicast datagrams through iface instead of
+// the one the routing table picks
+func MulticastInterface(iface *net.Interface) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		if isIPv6(network) {
+			return setOpt(c, "IPV6_MULTICAST_IF", syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index)
+		}
+
+		// IPv4 names the interface by one of its addresses
+		ip, err := interfaceIPv4(iface)
+		if err != nil {
+			return err
+		}
+		var addr [4]byte
+		copy(addr[:], ip)
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return &net.OpError{Op: "setsockopt IP_MULTICAST_IF", Err: err}
+		}
+		return nil
+	}
+}
+
+// Broadcast sets SO_BROADCAST, needed to send to broadcast addresses
+func Broadcast(network, address string, c syscall.RawConn) error {
+	return setOpt(c, "SO_BROADCAST", syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+}
+
+// interfaceIPv4 returns the first IPv4 address of iface
+func interfaceIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, errors.New("interface " + iface.Name + " has no IPv4 address")
+}
@@ -0,0 +1,119 @@
+package sockopt
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// Options are socket options applied to dialed and listening sockets. The
+// zero value leaves every option to the system and Go defaults.
+type Options struct {
+	// KeepAlive is the idle time before the first keepalive probe,
+	// KeepAliveInterval the time between probes and KeepAliveCount how many
+	// unanswered probes drop the connection. Any of them enables keepalive.
+	KeepAlive         time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+
+	// Nagle turns Nagle's algorithm back on, Go sets TCP_NODELAY by default
+	Nagle bool
+
+	// SendBuffer and ReceiveBuffer size the kernel buffers (SO_SNDBUF, SO_RCVBUF)
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// Linger makes Close wait up to this long for unsent data (SO_LINGER).
+	// ResetOnClose drops unsent data and closes with a RST instead.
+	Linger       time.Duration
+	ResetOnClose bool
+
+	// UserTimeout drops the connection when sent data stays unacknowledged
+	// this long (TCP_USER_TIMEOUT, Linux only)
+	UserTimeout time.Duration
+
+	// TOS is the IPv4 type of service or IPv6 traffic class byte, a DSCP
+	// value goes in its upper six bits
+	TOS int
+}
+
+// KeepAliveSet reports whether keepalive is configured. Dialers and listen
+// configs must then have a negative KeepAlive so Go keeps its hands off.
+func (o Options) KeepAliveSet() bool {
+	return o.KeepAlive > 0 || o.KeepAliveInterval > 0 || o.KeepAliveCount > 0
+}
+
+// IsZero reports whether no option is set
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// Control returns the hook setting the options on a socket before it
+// connects or listens. Options of accepted sockets are inherited from the
+// listening one.
+func (o Options) Control() Control {
+	return func(network, address string, c syscall.RawConn) error {
+		if o.SendBuffer > 0 {
+			if err := setOpt(c, "SO_SNDBUF", syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer); err != nil {
+				return err
+			}
+		}
+		if o.ReceiveBuffer > 0 {
+			if err := setOpt(c, "SO_RCVBUF", syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReceiveBuffer); err != nil {
+				return err
+			}
+		}
+		if o.TOS > 0 {
+			if err := setTOS(c, network, o.TOS); err != nil {
+				return err
+			}
+		}
+
+		// The rest only makes sense for TCP
+		if !isTCP(network) {
+			return nil
+		}
+		if o.KeepAliveSet() {
+			if err := setKeepAlive(c, o.KeepAlive, o.KeepAliveInterval, o.KeepAliveCount); err != nil {
+				return err
+			}
+		}
+		if o.Linger > 0 || o.ResetOnClose {
+			if err := setLinger(c, o.Linger, o.ResetOnClose); err != nil {
+				return err
+			}
+		}
+		if o.UserTimeout > 0 {
+			if err := setUserTimeout(c, o.UserTimeout); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Apply sets the options Go resets on every new connection, it is called
+// after dialing and after accepting
+func (o Options) Apply(conn net.Conn) error {
+	if tcp, ok := conn.(*net.TCPConn); ok && o.Nagle {
+		return tcp.SetNoDelay(false)
+	}
+	return nil
+}
+
+// setOpt sets an integer option and names it in the error
+func setOpt(c syscall.RawConn, name string, level, opt, value int) error {
+	if err := setInt(c, level, opt, value); err != nil {
+		return &net.OpError{Op: "setsockopt " + name, Err: err}
+	}
+	return nil
+}
+
+func isTCP(network string) bool {
+	return network == "tcp" || network == "tcp4" || network == "tcp6"
+}
+
+// seconds rounds d up to whole seconds, at least one
+func seconds(d time.Duration) int {
+	return max(1, int((d+time.Second-1)/time.Second))
+}
@@ -0,0 +1,81 @@
+package sockopt
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOptionsControl(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+
+	options := Options{
+		KeepAlive:         30 * time.Second,
+		KeepAliveInterval: 5 * time.Second,
+		KeepAliveCount:    4,
+		SendBuffer:        64 << 10,
+		UserTimeout:       1500 * time.Millisecond,
+		TOS:               46 << 2,
+	}
+	dialer := net.Dialer{Control: options.Control(), KeepAlive: -1}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tcp := conn.(*net.TCPConn)
+
+	for _, c := range []struct {
+		name       string
+		level, opt int
+		want       int
+	}{
+		{"SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 30},
+		{"TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, 5},
+		{"TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 4},
+		{"TCP_USER_TIMEOUT", syscall.IPPROTO_TCP, tcpUserTimeout, 1500},
+		{"IP_TOS", syscall.IPPROTO_IP, syscall.IP_TOS, 46 << 2},
+	} {
+		if got := getInt(t, tcp, c.level, c.opt); got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	// Linux doubles the requested buffer size for its bookkeeping
+	if got := getInt(t, tcp, syscall.SOL_SOCKET, syscall.SO_SNDBUF); got < 64<<10 {
+		t.Errorf("SO_SNDBUF = %d, want at least %d", got, 64<<10)
+	}
+}
+
+func TestResetOnClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+
+	dialer := net.Dialer{Control: Options{ResetOnClose: true}.Control()}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn.Close()
+
+	// The peer sees a reset rather than the end of the stream
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected a connection reset, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Package sockopt sets socket options through the Control hook of
+// net.Dialer and net.ListenConfig, before the socket is bound or connected
+package sockopt
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Control is the signature of net.Dialer.Control and net.ListenConfig.Control
+type Control func(network, address string, c syscall.RawConn) error
+
+// ErrNotSupported is returned for options the platform does not provide
+var ErrNotSupported = errors.New("socket option not supported on this platform")
+
+// Chain runs the controls in order and stops at the first error. Nil
+// controls are skipped, so an existing hook can be extended safely.
+func Chain(controls ...Control) Control {
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range controls {
+			if control == nil {
+				continue
+			}
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// setInt sets an integer option on the raw socket
+func setInt(c syscall.RawConn, level, name, value int) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = setsockoptInt(fd, level, name, value)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package sockopt
+
+import (
+	"syscall"
+	"time"
+)
+
+// ReuseAddr is not supported on this platform
+func ReuseAddr(network, address string, c syscall.RawConn) error {
+	return ErrNotSupported
+}
+
+func setsockoptInt(fd uintptr, level, name, value int) error {
+	return ErrNotSupported
+}
+
+func setTOS(c syscall.RawConn, network string, tos int) error {
+	return ErrNotSupported
+}
+
+func setLinger(c syscall.RawConn, linger time.Duration, reset bool) error {
+	return ErrNotSupported
+}
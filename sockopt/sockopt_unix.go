@@ -0,0 +1,50 @@
+//go:build unix
+
+package sockopt
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ReuseAddr sets SO_REUSEADDR so a fixed local port can be bound again while
+// an earlier connection from it lingers in TIME_WAIT
+func ReuseAddr(network, address string, c syscall.RawConn) error {
+	if err := setInt(c, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return os.NewSyscallError("setsockopt SO_REUSEADDR", err)
+	}
+	return nil
+}
+
+func setsockoptInt(fd uintptr, level, name, value int) error {
+	return syscall.SetsockoptInt(int(fd), level, name, value)
+}
+
+// setTOS sets the IPv4 type of service or the IPv6 traffic class
+func setTOS(c syscall.RawConn, network string, tos int) error {
+	if network == "tcp6" || network == "udp6" {
+		return setOpt(c, "IPV6_TCLASS", syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return setOpt(c, "IP_TOS", syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
+
+// setLinger sets SO_LINGER, reset closes with a RST without waiting
+func setLinger(c syscall.RawConn, linger time.Duration, reset bool) error {
+	l := &syscall.Linger{Onoff: 1, Linger: int32(seconds(linger))}
+	if reset {
+		l.Linger = 0
+	}
+
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptLinger(int(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, l)
+	}); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return &net.OpError{Op: "setsockopt SO_LINGER", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build unix
+
+package sockopt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// getInt reads an integer option from a connection
+func getInt(t *testing.T, conn syscall.Conn, level, name int) int {
+	t.Helper()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	raw.Control(func(fd uintptr) {
+		value, err = syscall.GetsockoptInt(int(fd), level, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestReuseAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+
+	dialer := net.Dialer{Control: ReuseAddr}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if getInt(t, conn.(*net.TCPConn), syscall.SOL_SOCKET, syscall.SO_REUSEADDR) == 0 {
+		t.Error("expected SO_REUSEADDR to be set")
+	}
+}
+
+func TestChainStopsAtFirstError(t *testing.T) {
+	failure := errors.New("refused")
+	var calls int
+	count := func(network, address string, c syscall.RawConn) error {
+		calls++
+		return nil
+	}
+	fail := func(network, address string, c syscall.RawConn) error {
+		return failure
+	}
+
+	err := Chain(count, nil, fail, count)("tcp", "127.0.0.1:1", nil)
+	if !errors.Is(err, failure) || calls != 1 {
+		t.Errorf("expected to stop after the failing control, got %v after %d calls", err, calls)
+	}
+}
+
+func TestMulticastOptions(t *testing.T) {
+	config := net.ListenConfig{Control: Chain(MulticastTTL(4), MulticastLoopback(false), Broadcast)}
+	pc, err := config.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	conn := pc.(*net.UDPConn)
+
+	if got := getInt(t, conn, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL); got != 4 {
+		t.Errorf("IP_MULTICAST_TTL = %d, want 4", got)
+	}
+	if got := getInt(t, conn, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP); got != 0 {
+		t.Errorf("IP_MULTICAST_LOOP = %d, want 0", got)
+	}
+	if got := getInt(t, conn, syscall.SOL_SOCKET, syscall.SO_BROADCAST); got != 1 {
+		t.Errorf("SO_BROADCAST = %d, want 1", got)
+	}
+}
@@ -0,0 +1,36 @@
+package sockopt
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT, missing from the syscall package
+const tcpUserTimeout = 0x12
+
+// setKeepAlive enables keepalive and tunes the probes, zero values keep
+// the system defaults
+func setKeepAlive(c syscall.RawConn, idle, interval time.Duration, count int) error {
+	if err := setOpt(c, "SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if idle > 0 {
+		if err := setOpt(c, "TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(idle)); err != nil {
+			return err
+		}
+	}
+	if interval > 0 {
+		if err := setOpt(c, "TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(interval)); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return setOpt(c, "TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	}
+	return nil
+}
+
+// setUserTimeout sets TCP_USER_TIMEOUT in milliseconds
+func setUserTimeout(c syscall.RawConn, timeout time.Duration) error {
+	return setOpt(c, "TCP_USER_TIMEOUT", syscall.IPPROTO_TCP, tcpUserTimeout, int(timeout.Milliseconds()))
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package sockopt
+
+import (
+	"syscall"
+	"time"
+)
+
+// setKeepAlive only enables keepalive, tuning the probes needs Linux
+func setKeepAlive(c syscall.RawConn, idle, interval time.Duration, count int) error {
+	if idle > 0 || interval > 0 || count > 0 {
+		return ErrNotSupported
+	}
+	return setOpt(c, "SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+}
+
+func setUserTimeout(c syscall.RawConn, timeout time.Duration) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,254 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gppmad/gonc/internal/units"
+)
+
+// Sides reported in Summary.ClosedFirst
+const (
+	SideLocal  = "local"  // Our input reached EOF first
+	SideRemote = "remote" // The peer closed the connection first
+)
+
+// Stats collects the traffic of a single connection. Counters are safe to
+// update from the reading and the writing goroutines at the same time.
+type Stats struct {
+	sent     atomic.Int64
+	received atomic.Int64
+	now      func() time.Time
+
+	mu          sync.Mutex
+	remoteAddr  string
+	connected   time.Time
+	firstByte   time.Time
+	closed      time.Time
+	closeReason string
+	closedFirst string
+}
+
+// New starts collecting statistics, the connection is considered established now
+func New() *Stats {
+	s := &Stats{now: time.Now}
+	s.connected = s.now()
+	return s
+}
+
+// MarkConnected records now as the time the connection was established
+func (s *Stats) MarkConnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = s.now()
+}
+
+// Conn wraps conn so its traffic is counted. Bytes read are received,
+// bytes written are sent.
+func (s *Stats) Conn(conn net.Conn) net.Conn {
+	s.mu.Lock()
+	if s.remoteAddr == "" && conn.RemoteAddr() != nil {
+		s.remoteAddr = conn.RemoteAddr().String()
+	}
+	s.mu.Unlock()
+
+	return &countingConn{Conn: conn, stats: s}
+}
+
+// Input wraps the local input so its end is recorded as a local close
+func (s *Stats) Input(r io.Reader) io.Reader {
+	return &countingInput{r: r, stats: s}
+}
+
+// markClosed records which side finished first
+func (s *Stats) markClosed(side string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closedFirst == "" {
+		s.closedFirst = side
+	}
+}
+
+// Finish records the end of the connection and the error that ended it, if any
+func (s *Stats) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = s.now()
+	switch {
+	case err != nil:
+		s.closeReason = err.Error()
+	case s.closedFirst == SideRemote:
+		s.closeReason = "closed by peer"
+	default:
+		s.closeReason = "end of input"
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	stats *Stats
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.stats.received.Add(int64(n)) == int64(n) {
+		c.stats.mu.Lock()
+		c.stats.firstByte = c.stats.now()
+		c.stats.mu.Unlock()
+	}
+	if errors.Is(err, io.EOF) {
+		c.stats.markClosed(SideRemote)
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.sent.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type countingInput struct {
+	r     io.Reader
+	stats *Stats
+}
+
+func (i *countingInput) Read(p []byte) (int, error) {
+	n, err := i.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		i.stats.markClosed(SideLocal)
+	}
+	return n, err
+}
+
+// WriteTo lets an input writing itself, such as the framing of datagrams,
+// keep control of the writes io.Copy makes
+func (i *countingInput) WriteTo(w io.Writer) (int64, error) {
+	wt, ok := i.r.(io.WriterTo)
+	if !ok {
+		// Hide WriteTo so io.Copy reads again instead of calling it back
+		return io.Copy(w, struct{ io.Reader }{i})
+	}
+
+	n, err := wt.WriteTo(w)
+	if err == nil {
+		i.stats.markClosed(SideLocal)
+	}
+	return n, err
+}
+
+// Summary is a snapshot of Stats ready to be printed
+type Summary struct {
+	RemoteAddr      string    `json:"remote_addr,omitempty"`
+	BytesSent       int64     `json:"bytes_sent"`
+	BytesReceived   int64     `json:"bytes_received"`
+	Connected       time.Time `json:"connected"`
+	Closed          time.Time `json:"closed"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	FirstByteAfter  float64   `json:"first_byte_seconds,omitempty"` // Zero when nothing was received
+	SendRate        float64   `json:"send_bytes_per_second"`
+	ReceiveRate     float64   `json:"receive_bytes_per_second"`
+	CloseReason     string    `json:"close_reason"`
+	ClosedFirst     string    `json:"closed_first,omitempty"`
+}
+
+// Summary returns a snapshot of the statistics. A connection still open is
+// measured up to now.
+func (s *Stats) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	closed := s.closed
+	if closed.IsZero() {
+		closed = s.now()
+	}
+
+	sum := Summary{
+		RemoteAddr:    s.remoteAddr,
+		BytesSent:     s.sent.Load(),
+		BytesReceived: s.received.Load(),
+		Connected:     s.connected,
+		Closed:        closed,
+		CloseReason:   s.closeReason,
+		ClosedFirst:   s.closedFirst,
+	}
+
+	duration := closed.Sub(s.connected).Seconds()
+	sum.DurationSeconds = duration
+	if duration > 0 {
+		sum.SendRate = float64(sum.BytesSent) / duration
+		sum.ReceiveRate = float64(sum.BytesReceived) / duration
+	}
+	if !s.firstByte.IsZero() {
+		sum.FirstByteAfter = s.firstByte.Sub(s.connected).Seconds()
+	}
+
+	return sum
+}
+
+// WriteText prints the summary in a human readable form
+func (sum Summary) WriteText(w io.Writer) error {
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+
+	var b strings.Builder
+	b.WriteString("Connection statistics")
+	if sum.RemoteAddr != "" {
+		fmt.Fprintf(&b, " for %s", sum.RemoteAddr)
+	}
+	fmt.Fprintf(&b, "\n  sent:       %d bytes (%s/s)\n", sum.BytesSent, units.FormatBytes(sum.SendRate))
+	fmt.Fprintf(&b, "  received:   %d bytes (%s/s)\n", sum.BytesReceived, units.FormatBytes(sum.ReceiveRate))
+	fmt.Fprintf(&b, "  duration:   %v\n", seconds(sum.DurationSeconds))
+	if sum.FirstByteAfter > 0 {
+		fmt.Fprintf(&b, "  first byte: %v\n", seconds(sum.FirstByteAfter))
+	}
+	fmt.Fprintf(&b, "  closed:     %s", sum.CloseReason)
+	if sum.ClosedFirst != "" {
+		fmt.Fprintf(&b, ", %s side first", sum.ClosedFirst)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON prints the summary as a single JSON object
+func (sum Summary) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(sum)
+}
+
+// Write prints the summary in the given format, "text" or "json"
+func (sum Summary) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return sum.WriteJSON(w)
+	case "text":
+		return sum.WriteText(w)
+	}
+	return fmt.Errorf("unknown statistics format %q", format)
+}
+
+// Handler wraps a server connection handler so the traffic of every
+// connection is counted and reported once the handler returns
+func Handler(report func(*Stats), next func(conn net.Conn, input io.Reader, output io.Writer) error) func(conn net.Conn, input io.Reader, output io.Writer) error {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		s := New()
+		err := next(s.Conn(conn), s.Input(input), output)
+		s.Finish(err)
+		report(s)
+		return err
+	}
+}
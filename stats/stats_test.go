@@ -0,0 +1,185 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/udp"
+)
+
+// steppingClock returns a time one second later on every call
+func steppingClock() func() time.Time {
+	now := time.Unix(0, 0)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func TestCountsBothDirections(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	s := New()
+	conn := s.Conn(local)
+
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(remote, buf)
+		remote.Write([]byte("hello world"))
+		remote.Close()
+	}()
+
+	conn.Write([]byte("12345"))
+	io.ReadAll(conn)
+	s.Finish(nil)
+
+	sum := s.Summary()
+	if sum.BytesSent != 5 || sum.BytesReceived != 11 {
+		t.Errorf("expected 5 sent and 11 received, got %d and %d", sum.BytesSent, sum.BytesReceived)
+	}
+	if sum.ClosedFirst != SideRemote || sum.CloseReason != "closed by peer" {
+		t.Errorf("expected the remote side to close first, got %q (%q)", sum.ClosedFirst, sum.CloseReason)
+	}
+	if sum.RemoteAddr == "" {
+		t.Error("expected the remote address to be recorded")
+	}
+}
+
+func TestLocalInputClosesFirst(t *testing.T) {
+	s := New()
+	io.ReadAll(s.Input(strings.NewReader("input")))
+	s.Finish(nil)
+
+	if sum := s.Summary(); sum.ClosedFirst != SideLocal || sum.CloseReason != "end of input" {
+		t.Errorf("expected the local side to close first, got %q (%q)", sum.ClosedFirst, sum.CloseReason)
+	}
+}
+
+// Datagrams framed by the input go out whole through the counted connection,
+// even past the 32 KiB buffer of io.Copy and when empty
+func TestInputKeepsDatagrams(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	sizes := []int{40000, 0, 5}
+	var framed bytes.Buffer
+	for _, size := range sizes {
+		framed.Write([]byte{byte(size >> 8), byte(size)})
+		framed.Write(bytes.Repeat([]byte("x"), size))
+	}
+
+	s := New()
+	input := s.Input(udp.NewFrameReader(&framed, udp.FrameLengthPrefixed, 0))
+	if _, err := io.Copy(s.Conn(client), input); err != nil {
+		t.Fatal(err)
+	}
+	s.Finish(nil)
+
+	buf := make([]byte, udp.MaxPayload)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range sizes {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil || n != want {
+			t.Fatalf("expected a %d byte datagram, got %d, %v", want, n, err)
+		}
+	}
+	if sum := s.Summary(); sum.BytesSent != 40005 || sum.ClosedFirst != SideLocal {
+		t.Errorf("expected 40005 bytes sent and the input closed first, got %d and %q", sum.BytesSent, sum.ClosedFirst)
+	}
+}
+
+func TestTimestampsAndThroughput(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	s := New()
+	s.now = steppingClock()
+	s.MarkConnected() // t=1s
+	conn := s.Conn(local)
+
+	go remote.Write(make([]byte, 400))
+	io.ReadFull(conn, make([]byte, 400)) // first byte at t=2s
+
+	s.Finish(errors.New("connection reset")) // closed at t=3s
+
+	sum := s.Summary()
+	if sum.DurationSeconds != 2 {
+		t.Errorf("expected a duration of 2s, got %v", sum.DurationSeconds)
+	}
+	if sum.FirstByteAfter != 1 {
+		t.Errorf("expected the first byte after 1s, got %v", sum.FirstByteAfter)
+	}
+	if sum.ReceiveRate != 200 {
+		t.Errorf("expected 200 B/s received, got %v", sum.ReceiveRate)
+	}
+	if sum.CloseReason != "connection reset" {
+		t.Errorf("expected the error as close reason, got %q", sum.CloseReason)
+	}
+}
+
+func TestSummaryFormats(t *testing.T) {
+	s := New()
+	s.Finish(nil)
+	sum := s.Summary()
+
+	text := new(bytes.Buffer)
+	if err := sum.Write(text, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "sent:") || !strings.Contains(text.String(), "closed:") {
+		t.Errorf("unexpected text summary %q", text.String())
+	}
+
+	js := new(bytes.Buffer)
+	if err := sum.Write(js, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON summary: %v", err)
+	}
+	if _, ok := decoded["bytes_sent"]; !ok {
+		t.Errorf("expected bytes_sent in %s", js.String())
+	}
+
+	if err := sum.Write(io.Discard, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestHandlerReports(t *testing.T) {
+	local, remote := net.Pipe()
+	go remote.Write([]byte("ping"))
+
+	var reported *Stats
+	handler := Handler(func(s *Stats) { reported = s }, func(conn net.Conn, input io.Reader, output io.Writer) error {
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		return err
+	})
+
+	if err := handler(local, strings.NewReader(""), io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reported == nil {
+		t.Fatal("statistics were not reported")
+	}
+	if got := reported.Summary().BytesReceived; got != 4 {
+		t.Errorf("expected 4 bytes received, got %d", got)
+	}
+}
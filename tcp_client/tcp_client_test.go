@@ -2,11 +2,15 @@ package tcp_client
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"net"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gppmad/gonc/stats"
 )
 
 type myConn struct {
@@ -151,3 +155,55 @@ func TestServerErrorReadingFromConnection(t *testing.T) {
 		t.Fatalf("Expected error message to mention reading from connection, got: %v", err)
 	}
 }
+
+func TestClientStats(t *testing.T) {
+	input := bytes.NewBufferString("my input")
+
+	myConn := &myConn{}
+	myConn.IncomingBuffer.WriteString("this is coming from the server")
+	output := new(bytes.Buffer)
+
+	client := NewTcpClient(myConn, input, output)
+	client.Stats = stats.New()
+	if err := client.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sum := client.Stats.Summary()
+	if sum.BytesSent != int64(len("my input")) {
+		t.Errorf("expected %d bytes sent, got %d", len("my input"), sum.BytesSent)
+	}
+	if sum.BytesReceived != int64(output.Len()) {
+		t.Errorf("expected %d bytes received, got %d", output.Len(), sum.BytesReceived)
+	}
+	if sum.Closed.IsZero() {
+		t.Error("expected the session end to be recorded")
+	}
+}
+
+func TestStartContextCancel(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// Neither the input nor the peer ever send anything
+	input, _ := io.Pipe()
+	client := NewTcpClient(local, input, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- client.StartContext(ctx) }()
+
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StartContext did not return after cancel")
+	}
+
+	if _, err := remote.Write([]byte("x")); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,134 @@
+package tcp_server_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	tcp_server "github.com/gppmad/gonc/tcp_server"
+)
+
+// fakeListener hands out the scripted results, then blocks until closed
+type fakeListener struct {
+	mu      sync.Mutex
+	results []acceptResult
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newFakeListener(results ...acceptResult) *fakeListener {
+	return &fakeListener{results: results, closed: make(chan struct{})}
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if len(l.results) > 0 {
+		r := l.results[0]
+		l.results = l.results[1:]
+		l.mu.Unlock()
+		return r.conn, r.err
+	}
+	l.mu.Unlock()
+
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *fakeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *fakeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// acceptError wraps errno the way the net package reports accept failures
+func acceptError(errno syscall.Errno) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Err: errno}
+}
+
+func TestTemporaryAcceptErrorsAreRetried(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	listener := newFakeListener(
+		acceptResult{err: acceptError(syscall.EMFILE)},
+		acceptResult{err: acceptError(syscall.ECONNABORTED)},
+		acceptResult{conn: local},
+	)
+
+	handled := make(chan struct{})
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+	server.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+		close(handled)
+		return conn.Close()
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- server.Start() }()
+
+	select {
+	case <-handled:
+	case err := <-started:
+		t.Fatalf("Start returned on a temporary error: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection after the errors was not handled")
+	}
+	if got := server.Metrics.AcceptErrors.Load(); got != 2 {
+		t.Errorf("expected 2 retried accept errors, got %d", got)
+	}
+
+	server.Close()
+	if err := <-started; !errors.Is(err, tcp_server.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed after Close, got %v", err)
+	}
+}
+
+func TestPermanentAcceptErrorStopsServer(t *testing.T) {
+	failure := errors.New("listener broken")
+	listener := newFakeListener(acceptResult{err: failure})
+
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+	err := server.Start()
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the accept error, got %v", err)
+	}
+	if errors.Is(err, tcp_server.ErrServerClosed) {
+		t.Error("a failure must not look like a deliberate close")
+	}
+}
+
+func TestCloseDuringRetryBackoff(t *testing.T) {
+	// Keep failing so the server is always waiting to retry
+	results := make([]acceptResult, 100)
+	for i := range results {
+		results[i].err = acceptError(syscall.ENFILE)
+	}
+	listener := newFakeListener(results...)
+
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+	started := make(chan error, 1)
+	go func() { started <- server.Start() }()
+
+	waitFor(t, "a retried accept error", func() bool { return server.Metrics.AcceptErrors.Load() > 3 })
+	server.Close()
+
+	select {
+	case err := <-started:
+		if !errors.Is(err, tcp_server.ErrServerClosed) {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after Close")
+	}
+}
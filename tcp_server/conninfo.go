@@ -0,0 +1,150 @@
+package tcp_server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gppmad/gonc/proxyproto"
+)
+
+// Time allowed for the TLS handshake and the reverse lookup of a new connection
+const (
+	handshakeTimeout = 10 * time.Second
+	lookupTimeout    = 2 * time.Second
+)
+
+// ConnInfo describes an accepted connection
+type ConnInfo struct {
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+
+	// Hostname is the name the remote address resolves back to, empty
+	// without ReverseDNS or when the lookup fails
+	Hostname string
+
+	// ProxyAddr is the address of the proxy that sent a PROXY protocol
+	// header, RemoteAddr and LocalAddr then come from the header
+	ProxyAddr net.Addr
+
+	// TLS is the handshake state of TLS connections, nil otherwise
+	TLS *tls.ConnectionState
+
+	// Accepted is when the server accepted the connection
+	Accepted time.Time
+}
+
+// ClientSubject returns the subject of the TLS client certificate, empty
+// when the client sent none
+func (i *ConnInfo) ClientSubject() string {
+	if i.TLS == nil || len(i.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return i.TLS.PeerCertificates[0].Subject.String()
+}
+
+// String describes the connection on one line, such as
+// "192.0.2.7:51234 (host.example.com) on 192.0.2.1:8080"
+func (i *ConnInfo) String() string {
+	var b strings.Builder
+	b.WriteString(i.RemoteAddr.String())
+	if i.Hostname != "" {
+		fmt.Fprintf(&b, " (%s)", i.Hostname)
+	}
+	fmt.Fprintf(&b, " on %s", i.LocalAddr)
+	if i.ProxyAddr != nil {
+		fmt.Fprintf(&b, " via proxy %s", i.ProxyAddr)
+	}
+	if i.TLS != nil {
+		fmt.Fprintf(&b, ", %s", tls.VersionName(i.TLS.Version))
+		if subject := i.ClientSubject(); subject != "" {
+			fmt.Fprintf(&b, ", client certificate %s", subject)
+		}
+	}
+	return b.String()
+}
+
+// connInfos maps every connection being handled to the function
+// returning its information
+var connInfos sync.Map
+
+// ConnInfoOf returns the information of a connection accepted by a
+// TcpServer while its handler runs, nil for any other connection.
+// Connections wrapping it, such as the ones middleware create, are
+// unwrapped through their NetConn method.
+func ConnInfoOf(conn net.Conn) *ConnInfo {
+	for conn != nil {
+		if lookup, ok := connInfos.Load(conn); ok {
+			info, _ := lookup.(func() (*ConnInfo, error))()
+			return info
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil
+}
+
+// inspect collects the information of a new connection. The TLS handshake
+// is completed first so the client certificate is known, when it fails the
+// information comes without the TLS state.
+func (s *TcpServer) inspect(conn net.Conn) (*ConnInfo, error) {
+	info := &ConnInfo{RemoteAddr: conn.RemoteAddr(), LocalAddr: conn.LocalAddr(), Accepted: time.Now()}
+	if pc := proxyConn(conn); pc != nil {
+		info.ProxyAddr = pc.ProxyAddr()
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return info, fmt.Errorf("TLS handshake: %w", err)
+		}
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+
+	if s.ReverseDNS {
+		info.Hostname = reverseLookup(info.RemoteAddr)
+	}
+	return info, nil
+}
+
+// proxyConn finds the PROXY protocol connection beneath conn, such as the
+// one under a TLS connection, nil when there is none
+func proxyConn(conn net.Conn) *proxyproto.Conn {
+	for conn != nil {
+		if pc, ok := conn.(*proxyproto.Conn); ok {
+			return pc
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil
+}
+
+// reverseLookup returns the first name addr resolves back to, empty when
+// there is none
+func reverseLookup(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, host)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
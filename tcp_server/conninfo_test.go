@@ -0,0 +1,236 @@
+package tcp_server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gppmad/gonc/acl"
+	tcp_server "github.com/gppmad/gonc/tcp_server"
+)
+
+// wrappedConn stands for the connections middleware wraps around the accepted one
+type wrappedConn struct {
+	net.Conn
+}
+
+func (c *wrappedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func TestConnInfoOf(t *testing.T) {
+	infos := make(chan *tcp_server.ConnInfo, 1)
+	_, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			infos <- tcp_server.ConnInfoOf(conn)
+			return conn.Close()
+		}
+		s.Use(func(next tcp_server.Handler) tcp_server.Handler {
+			return func(conn net.Conn, input io.Reader, output io.Writer) error {
+				return next(&wrappedConn{conn}, input, output)
+			}
+		})
+	})
+
+	conn := dialMany(t, addr, 1)[0]
+	info := <-infos
+	if info == nil {
+		t.Fatal("expected the information of the accepted connection")
+	}
+	if info.RemoteAddr.String() != conn.LocalAddr().String() || info.LocalAddr.String() != addr {
+		t.Errorf("unexpected addresses %v", info)
+	}
+	if info.Hostname != "" || info.TLS != nil {
+		t.Errorf("expected no host name nor TLS state, got %v", info)
+	}
+
+	if tcp_server.ConnInfoOf(conn) != nil {
+		t.Error("expected no information for a connection the server did not accept")
+	}
+}
+
+func TestOnAcceptTLSClientCertificate(t *testing.T) {
+	cert := selfSigned(t, "gonc test client")
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSigned(t, "gonc test server")},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+
+	infos := make(chan *tcp_server.ConnInfo, 1)
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+	server.OnAccept = func(info *tcp_server.ConnInfo) { infos <- info }
+	server.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+		return conn.Close()
+	}
+	go server.Start()
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case info := <-infos:
+		if got := info.ClientSubject(); got != "CN=gonc test client" {
+			t.Errorf("expected the client certificate subject, got %q", got)
+		}
+		if !strings.Contains(info.String(), "client certificate CN=gonc test client") {
+			t.Errorf("expected the subject in %q", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnAccept was not called")
+	}
+}
+
+// selfSigned returns a certificate for name signed by its own key
+func selfSigned(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	infos := make(chan *tcp_server.ConnInfo, 1)
+	_, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.ProxyProtocol = true
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			infos <- tcp_server.ConnInfoOf(conn)
+			return conn.Close()
+		}
+	})
+
+	conn := dialMany(t, addr, 1)[0]
+	conn.Write([]byte("PROXY TCP4 198.51.100.7 203.0.113.1 40000 443\r\n"))
+
+	info := <-infos
+	if info.RemoteAddr.String() != "198.51.100.7:40000" || info.LocalAddr.String() != "203.0.113.1:443" {
+		t.Errorf("expected the addresses of the header, got %v", info)
+	}
+	if info.ProxyAddr == nil || info.ProxyAddr.String() != conn.LocalAddr().String() {
+		t.Errorf("expected the proxy address %v, got %v", conn.LocalAddr(), info.ProxyAddr)
+	}
+}
+
+// The PROXY header is awaited per connection: a client sending nothing
+// must not delay the next one, even when the ACL and the rate limit need
+// the address of the header
+func TestSilentProxyClientDoesNotBlockAccept(t *testing.T) {
+	list, err := acl.New([]string{"198.51.100.0/24"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loopback, err := acl.New([]string{"127.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotes := make(chan string, 1)
+	_, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.ProxyProtocol = true
+		s.ACL = list
+		s.TrustedProxies = loopback
+		s.PerIPRate = &tcp_server.RateLimit{Limit: 10, Window: time.Minute}
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			remotes <- conn.RemoteAddr().String()
+			return conn.Close()
+		}
+	})
+
+	// Connects and never sends its header
+	dialMany(t, addr, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	conn := dialMany(t, addr, 1)[0]
+	start := time.Now()
+	conn.Write([]byte("PROXY TCP4 198.51.100.7 203.0.113.1 40000 443\r\n"))
+
+	select {
+	case remote := <-remotes:
+		if remote != "198.51.100.7:40000" {
+			t.Errorf("expected the address of the header, got %s", remote)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("the second client waited %v behind the silent one", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the second client was held up by the silent one")
+	}
+}
+
+// A client forging the source of its PROXY header does not get past a deny
+// rule on its own address, nor past a trusted proxy list it is not on
+func TestSpoofedProxyHeaderIsRejected(t *testing.T) {
+	denyLoopback, err := acl.New(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere, err := acl.New([]string{"192.0.2.0/24"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(s *tcp_server.TcpServer){
+		"untrusted header": func(s *tcp_server.TcpServer) { s.ACL = denyLoopback },
+		"untrusted proxy":  func(s *tcp_server.TcpServer) { s.TrustedProxies = elsewhere },
+	}
+	for name, configure := range tests {
+		t.Run(name, func(t *testing.T) {
+			handled := make(chan struct{}, 1)
+			server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+				s.ProxyProtocol = true
+				configure(s)
+				s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+					handled <- struct{}{}
+					return conn.Close()
+				}
+			})
+
+			conn := dialMany(t, addr, 1)[0]
+			conn.Write([]byte("PROXY TCP4 198.51.100.7 203.0.113.1 40000 443\r\n"))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("expected the connection to be closed, got %v", err)
+			}
+
+			select {
+			case <-handled:
+				t.Fatal("the spoofed connection reached the handler")
+			default:
+			}
+			if rejected := server.Metrics.RejectedACL.Load(); rejected != 1 {
+				t.Errorf("expected 1 connection rejected by the ACL, got %d", rejected)
+			}
+		})
+	}
+}
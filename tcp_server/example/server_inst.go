@@ -1,12 +1,15 @@
 package example
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gppmad/gonc/tcp_server"
 )
@@ -19,7 +22,7 @@ func example() {
 		log.Fatalf("Failed to create listener: %v", err)
 	}
 
-	tcp_server := tcp_server.NewTcpServer(listener, nil, nil)
+	server := tcp_server.NewTcpServer(listener, nil, nil)
 	// Setup signal handling
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
@@ -28,16 +31,18 @@ func example() {
 	go func() {
 		sig := <-signalChan
 		fmt.Printf("Received signal: %v\n", sig)
-		if err := tcp_server.Close(); err != nil {
+		// Give active connections a few seconds to finish
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
 			log.Printf("Error closing server: %v", err)
 		}
 		fmt.Println("Server shut down gracefully")
-		os.Exit(0)
 	}()
 
 	// Run server in main thread
 	fmt.Println("Server started on :8080")
-	if err := tcp_server.Start(); err != nil {
+	if err := server.Start(); err != nil && !errors.Is(err, tcp_server.ErrServerClosed) {
 		log.Fatalf("Server error: %v", err)
 	}
 }
@@ -0,0 +1,39 @@
+package tcp_server
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// Handler serves a single accepted connection
+type Handler func(conn net.Conn, input io.Reader, output io.Writer) error
+
+// Middleware wraps a Handler to add behavior around it, such as logging,
+// authentication or metrics
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the middleware. The first one is the outermost, so it
+// sees the connection first and the returned error last.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// PanicError is returned for a connection whose handler panicked
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+// Unwrap exposes the panic value when it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
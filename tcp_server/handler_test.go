@@ -0,0 +1,108 @@
+package tcp_server_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tcp_server "github.com/gppmad/gonc/tcp_server"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) tcp_server.Middleware {
+		return func(next tcp_server.Handler) tcp_server.Handler {
+			return func(conn net.Conn, input io.Reader, output io.Writer) error {
+				calls = append(calls, name+" in")
+				err := next(conn, input, output)
+				calls = append(calls, name+" out")
+				return err
+			}
+		}
+	}
+
+	handler := tcp_server.Chain(func(conn net.Conn, input io.Reader, output io.Writer) error {
+		calls = append(calls, "handler")
+		return nil
+	}, trace("first"), trace("second"))
+
+	handler(nil, nil, nil)
+
+	want := "first in,second in,handler,second out,first out"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("expected calls %q, got %q", want, got)
+	}
+}
+
+func TestMiddlewareCanReject(t *testing.T) {
+	denied := errors.New("authentication failed")
+	errs := make(chan error, 1)
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			t.Error("the handler should not run")
+			return nil
+		}
+		s.Use(func(next tcp_server.Handler) tcp_server.Handler {
+			return func(conn net.Conn, input io.Reader, output io.Writer) error {
+				conn.Close()
+				return denied
+			}
+		})
+		s.OnError = func(conn net.Conn, err error) { errs <- err }
+	})
+
+	dialMany(t, addr, 1)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, denied) {
+			t.Errorf("expected the middleware error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+	if got := server.Metrics.Failed.Load(); got != 1 {
+		t.Errorf("expected 1 failed connection, got %d", got)
+	}
+}
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	errs := make(chan error, 2)
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			panic("boom")
+		}
+		s.OnError = func(conn net.Conn, err error) { errs <- err }
+	})
+
+	// The server keeps serving after the first panic
+	for i := 0; i < 2; i++ {
+		conn := dialMany(t, addr, 1)[0]
+
+		select {
+		case err := <-errs:
+			var perr *tcp_server.PanicError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a *PanicError, got %v", err)
+			}
+			if perr.Value != "boom" || !strings.Contains(string(perr.Stack), "handler_test.go") {
+				t.Errorf("unexpected panic details: %v\n%s", perr.Value, perr.Stack)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnError was not called")
+		}
+
+		// The connection of the panicking handler is closed
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("expected EOF on the connection, got %v", err)
+		}
+	}
+
+	if got := server.Metrics.Panics.Load(); got != 2 {
+		t.Errorf("expected 2 panics, got %d", got)
+	}
+}
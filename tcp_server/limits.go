@@ -0,0 +1,114 @@
+package tcp_server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnPolicy decides what happens to connections over TcpServer.MaxConns
+type ConnPolicy int
+
+const (
+	// PolicyReject closes new connections while the server is full
+	PolicyReject ConnPolicy = iota
+	// PolicyQueue stops accepting until a slot frees up, new clients wait
+	// in the listen backlog of the kernel
+	PolicyQueue
+)
+
+// ParseConnPolicy parses "reject" or "queue"
+func ParseConnPolicy(policy string) (ConnPolicy, error) {
+	switch policy {
+	case "reject":
+		return PolicyReject, nil
+	case "queue":
+		return PolicyQueue, nil
+	}
+	return PolicyReject, fmt.Errorf("unknown connection policy %q, expected reject or queue", policy)
+}
+
+// Metrics counts what happened to the connections of a server
+type Metrics struct {
+	Accepted         atomic.Int64 // Connections handed to the handler
+	Active           atomic.Int64 // Connections currently handled
+	RejectedACL      atomic.Int64 // Closed because of the access control list
+	RejectedMaxConns atomic.Int64 // Closed because the server was full
+	RejectedRate     atomic.Int64 // Closed because the source connected too often
+	Failed           atomic.Int64 // Handlers that returned an error, panics included
+	Panics           atomic.Int64 // Handlers that panicked
+	AcceptErrors     atomic.Int64 // Temporary accept errors that were retried
+}
+
+// RateLimit allows at most Limit connections per source IP within Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRateLimit parses a limit written as COUNT/DURATION, such as 10/1m
+func ParseRateLimit(limit string) (RateLimit, error) {
+	count, window, ok := strings.Cut(limit, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected COUNT/DURATION such as 10/1m", limit)
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected COUNT/DURATION such as 10/1m", limit)
+	}
+	return RateLimit{Limit: n, Window: d}, nil
+}
+
+// ipRateLimiter keeps the recent connection times of every source IP
+type ipRateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+	mu    sync.Mutex
+	hits  map[string][]time.Time
+	swept time.Time
+}
+
+func newIPRateLimiter(limit RateLimit) *ipRateLimiter {
+	return &ipRateLimiter{limit: limit, now: time.Now, hits: make(map[string][]time.Time)}
+}
+
+// allow records a connection from addr and reports whether it is within the limit
+func (l *ipRateLimiter) allow(addr net.Addr) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	cutoff := now.Add(-l.limit.Window)
+
+	// Forget sources that have been quiet for a whole window
+	if now.Sub(l.swept) >= l.limit.Window {
+		for h, times := range l.hits {
+			if times[len(times)-1].Before(cutoff) {
+				delete(l.hits, h)
+			}
+		}
+		l.swept = now
+	}
+
+	recent := l.hits[host]
+	for len(recent) > 0 && !recent[0].After(cutoff) {
+		recent = recent[1:]
+	}
+	if len(recent) >= l.limit.Limit {
+		l.hits[host] = recent
+		return false
+	}
+	l.hits[host] = append(recent, now)
+	return true
+}
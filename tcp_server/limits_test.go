@@ -0,0 +1,181 @@
+package tcp_server_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tcp_server "github.com/gppmad/gonc/tcp_server"
+)
+
+// startLocalServer runs server on a loopback listener and returns its address
+func startLocalServer(t *testing.T, configure func(*tcp_server.TcpServer)) (*tcp_server.TcpServer, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+	configure(server)
+	go server.Start()
+	t.Cleanup(func() { server.Close() })
+
+	return server, listener.Addr().String()
+}
+
+// dialMany opens n client connections concurrently
+func dialMany(t *testing.T, addr string, n int) []net.Conn {
+	t.Helper()
+
+	conns := make([]net.Conn, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Errorf("dial failed: %v", err)
+				return
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	})
+	return conns
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	release := make(chan struct{})
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.MaxConns = 5
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			<-release
+			return conn.Close()
+		}
+	})
+
+	dialMany(t, addr, 20)
+
+	waitFor(t, "all connections to be accepted or rejected", func() bool {
+		return server.Metrics.Accepted.Load()+server.Metrics.RejectedMaxConns.Load() == 20
+	})
+
+	if got := server.Metrics.Accepted.Load(); got != 5 {
+		t.Errorf("expected 5 handled connections, got %d", got)
+	}
+	if got := server.Metrics.Active.Load(); got != 5 {
+		t.Errorf("expected 5 active connections, got %d", got)
+	}
+
+	// Once the handlers finish, new clients are accepted again
+	close(release)
+	waitFor(t, "handlers to finish", func() bool { return server.Metrics.Active.Load() == 0 })
+
+	dialMany(t, addr, 1)
+	waitFor(t, "a new connection after the slots freed up", func() bool {
+		return server.Metrics.Accepted.Load() == 6
+	})
+}
+
+func TestMaxConnsQueue(t *testing.T) {
+	var current, peak atomic.Int64
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.MaxConns = 3
+		s.ConnPolicy = tcp_server.PolicyQueue
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			n := current.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			current.Add(-1)
+			return conn.Close()
+		}
+	})
+
+	dialMany(t, addr, 20)
+
+	waitFor(t, "every queued connection to be handled", func() bool {
+		return server.Metrics.Accepted.Load() == 20 && server.Metrics.Active.Load() == 0
+	})
+
+	if got := peak.Load(); got > 3 {
+		t.Errorf("expected at most 3 concurrent handlers, got %d", got)
+	}
+	if got := server.Metrics.RejectedMaxConns.Load(); got != 0 {
+		t.Errorf("queue policy should not reject, rejected %d", got)
+	}
+}
+
+func TestPerIPRateLimit(t *testing.T) {
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.PerIPRate = &tcp_server.RateLimit{Limit: 3, Window: time.Minute}
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			return conn.Close()
+		}
+	})
+
+	dialMany(t, addr, 5)
+
+	waitFor(t, "all connections to be processed", func() bool {
+		return server.Metrics.Accepted.Load()+server.Metrics.RejectedRate.Load() == 5
+	})
+	if got := server.Metrics.Accepted.Load(); got != 3 {
+		t.Errorf("expected 3 connections within the rate, got %d", got)
+	}
+	if got := server.Metrics.RejectedRate.Load(); got != 2 {
+		t.Errorf("expected 2 rate limited connections, got %d", got)
+	}
+}
+
+func TestParseConnPolicy(t *testing.T) {
+	if p, err := tcp_server.ParseConnPolicy("queue"); err != nil || p != tcp_server.PolicyQueue {
+		t.Errorf("ParseConnPolicy(queue) = %v, %v", p, err)
+	}
+	if _, err := tcp_server.ParseConnPolicy("drop"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	got, err := tcp_server.ParseRateLimit("10/1m")
+	if err != nil || got != (tcp_server.RateLimit{Limit: 10, Window: time.Minute}) {
+		t.Errorf("ParseRateLimit(10/1m) = %+v, %v", got, err)
+	}
+
+	for _, input := range []string{"10", "0/1m", "x/1m", "10/forever", "10/-1s"} {
+		if _, err := tcp_server.ParseRateLimit(input); err == nil {
+			t.Errorf("ParseRateLimit(%q) should fail", input)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tcp_server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tcp_server "github.com/gppmad/gonc/tcp_server"
+)
+
+func TestShutdownWaitsForHandlers(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			<-release
+			close(finished)
+			return conn.Close()
+		}
+	})
+
+	dialMany(t, addr, 1)
+	waitFor(t, "the connection to be handled", func() bool { return server.Metrics.Active.Load() == 1 })
+
+	result := make(chan error, 1)
+	go func() { result <- server.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("Shutdown returned before the handler finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// No new connections once shutdown started
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Error("expected the listener to be closed")
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("handler did not run to completion")
+	}
+}
+
+func TestShutdownForcesCloseAfterDeadline(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			// Blocks until the connection is closed under it
+			_, err := conn.Read(make([]byte, 1))
+			handlerErr <- err
+			return err
+		}
+	})
+
+	dialMany(t, addr, 1)
+	waitFor(t, "the connection to be handled", func() bool { return server.Metrics.Active.Load() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to expire, got %v", err)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if err == nil {
+			t.Error("expected the read to fail on the closed connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler still blocked after a forced shutdown")
+	}
+}
+
+func TestShutdownStopsStart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+
+	started := make(chan error, 1)
+	go func() { started <- server.Start() }()
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	select {
+	case err := <-started:
+		if !errors.Is(err, tcp_server.ErrServerClosed) {
+			t.Errorf("Start should return ErrServerClosed after a shutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
+
+func TestServeContextCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	server := tcp_server.NewTcpServer(listener, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.ServeContext(ctx) }()
+
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeContext did not return after cancel")
+	}
+}
+
+// Without DrainTimeout, Close only stops accepting like it always did
+func TestCloseLeavesConnectionsRunning(t *testing.T) {
+	received := make(chan byte, 1)
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			defer conn.Close()
+			buf := make([]byte, 1)
+			if _, err := conn.Read(buf); err != nil {
+				return err
+			}
+			received <- buf[0]
+			return nil
+		}
+	})
+
+	conn := dialMany(t, addr, 1)[0]
+	waitFor(t, "the connection to be handled", func() bool { return server.Metrics.Active.Load() == 1 })
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Error("expected the listener to be closed")
+	}
+
+	conn.Write([]byte("x"))
+	select {
+	case b := <-received:
+		if b != 'x' {
+			t.Errorf("expected x, got %q", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the active connection did not survive Close")
+	}
+}
+
+func TestCloseDrainsWithTimeout(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	server, addr := startLocalServer(t, func(s *tcp_server.TcpServer) {
+		s.DrainTimeout = 20 * time.Millisecond
+		s.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+			_, err := conn.Read(make([]byte, 1))
+			handlerErr <- err
+			return err
+		}
+	})
+
+	dialMany(t, addr, 1)
+	waitFor(t, "the connection to be handled", func() bool { return server.Metrics.Active.Load() == 1 })
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	select {
+	case err := <-handlerErr:
+		if err == nil {
+			t.Error("expected the read to fail on the closed connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler still blocked after the drain timeout")
+	}
+}
@@ -1,10 +1,30 @@
 package tcp_server
 
 import (
+	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gppmad/gonc/acl"
+	"github.com/gppmad/gonc/logging"
+	"github.com/gppmad/gonc/proxyproto"
+	"github.com/gppmad/gonc/session"
+)
+
+// ErrServerClosed is returned by Start and ServeContext after Close or Shutdown
+var ErrServerClosed = errors.New("server closed")
+
+// Bounds of the delay between retries after a temporary accept error
+const (
+	minRetryDelay = 5 * time.Millisecond
+	maxRetryDelay = time.Second
 )
 
 // TcpServer represents a TCP server that can accept connections
@@ -15,7 +35,58 @@ type TcpServer struct {
 
 	// Adding a new field to control server behavior.
 	// This is the function called everytime the the listener accepts a connection.
-	Handler func(conn net.Conn, input io.Reader, output io.Writer) error
+	Handler Handler
+
+	// OnError, when set, receives the error of every handler that failed.
+	// A panicking handler is recovered and reported as a *PanicError.
+	OnError func(conn net.Conn, err error)
+
+	// Logger receives accept and close events, nil discards them
+	Logger *slog.Logger
+
+	// ProxyProtocol expects a PROXY protocol header on every connection,
+	// RemoteAddr and LocalAddr then report the original addresses. A TLS
+	// listener needs the header parsed beneath it, see proxyproto.Listener.
+	ProxyProtocol bool
+
+	// ReverseDNS looks up the host name of every client, see ConnInfo
+	ReverseDNS bool
+
+	// OnAccept, when set, receives the information of every accepted
+	// connection before its handler runs
+	OnAccept func(info *ConnInfo)
+
+	// ACL, when set, is checked for every accepted connection.
+	// Rejected connections are closed before reaching the handler.
+	ACL *acl.List
+
+	// TrustedProxies lists the peers whose PROXY protocol header is
+	// believed, connections from other peers are rejected. When nil the
+	// header may be forged by the client, so the ACL and the rate limit
+	// apply to the peer that connected as well as to the address it claims.
+	TrustedProxies *acl.List
+
+	// MaxConns bounds the connections handled at the same time, 0 means
+	// unlimited. ConnPolicy decides what happens to the ones over the limit.
+	MaxConns   int
+	ConnPolicy ConnPolicy
+
+	// PerIPRate, when set, limits how often a single source IP may connect
+	PerIPRate *RateLimit
+
+	// Metrics is updated while the server runs
+	Metrics Metrics
+
+	// DrainTimeout is how long Close waits for active connections to
+	// finish before closing them. 0 leaves them running, so Close behaves
+	// as it always did for callers that never set it.
+	DrainTimeout time.Duration
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	handlers sync.WaitGroup
+	closing  bool
+	done     chan struct{}
 }
 
 // NewTcpServer creates a new TCP server instance with the specified components
@@ -39,51 +110,399 @@ func NewTcpServer(listener net.Listener, input io.Reader, output io.Writer) *Tcp
 // DefaultHandler is the standard connection handling logic
 func DefaultHandler(conn net.Conn, input io.Reader, output io.Writer) error {
 	defer conn.Close()
+	return session.Copy(context.Background(), conn, input, output)
+}
 
-	// Create channels for error handling
-	errChan := make(chan error, 1)
-
-	// Read from the connection and write to output
-	go func() {
-		_, err := io.Copy(output, conn)
-		errChan <- err
-	}()
+// Start begins accepting connections and handling them
+func (s *TcpServer) Start() error {
+	return s.ServeContext(context.Background())
+}
 
-	// Read from input and write to connection
-	_, err := io.Copy(conn, input)
-	if err != nil {
-		<-errChan // Drain channel
-		return err
-	}
+// ServeContext is like Start but closes the server, as Close does, when ctx
+// is done. It then returns the context error.
+func (s *TcpServer) ServeContext(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
 
-	// Check for errors from the read goroutine
-	if err := <-errChan; err != nil {
-		return err
+	err := s.serve()
+	if errors.Is(err, ErrServerClosed) && ctx.Err() != nil {
+		return ctx.Err()
 	}
-
-	return nil
+	return err
 }
 
-// Start begins accepting connections and handling them
-func (s *TcpServer) Start() error {
+// serve runs the accept loop until the listener is closed. It always
+// returns an error, ErrServerClosed after Close or Shutdown.
+func (s *TcpServer) serve() error {
 	if s.Listener == nil {
 		return errors.New("listener not initialized")
 	}
 
+	var slots chan struct{}
+	if s.MaxConns > 0 {
+		slots = make(chan struct{}, s.MaxConns)
+	}
+
+	var limiter *ipRateLimiter
+	if s.PerIPRate != nil && s.PerIPRate.Limit > 0 {
+		limiter = newIPRateLimiter(*s.PerIPRate)
+	}
+
+	listener := s.Listener
+	if s.ProxyProtocol {
+		listener = proxyproto.NewListener(listener, 0)
+	}
+
+	done := s.doneChan()
+	var retryDelay time.Duration
 	for {
-		conn, err := s.Listener.Accept()
+		// Waiting for a free slot before accepting keeps new clients in the backlog
+		if slots != nil && s.ConnPolicy == PolicyQueue {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return ErrServerClosed
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if !isTemporary(err) {
+				return err
+			}
+
+			// Running out of file descriptors and similar conditions usually
+			// pass, wait a little and try again like net/http does
+			if slots != nil && s.ConnPolicy == PolicyQueue {
+				<-slots
+			}
+			retryDelay = nextRetryDelay(retryDelay)
+			s.Metrics.AcceptErrors.Add(1)
+			logging.OrDiscard(s.Logger).Warn("accept error, retrying", "error", err, "delay", retryDelay)
+			select {
+			case <-time.After(retryDelay):
+			case <-done:
+				return ErrServerClosed
+			}
+			continue
+		}
+		retryDelay = 0
+
+		go s.accept(conn, slots, limiter)
+	}
+}
+
+// accept admits and serves a new connection. It runs on its own goroutine:
+// with ProxyProtocol the remote address waits for the PROXY header, and a
+// client slow to send it must not hold up the others.
+func (s *TcpServer) accept(conn net.Conn, slots chan struct{}, limiter *ipRateLimiter) {
+	logger := logging.OrDiscard(s.Logger).With(logging.RemoteAddr(conn))
+
+	if !s.admit(conn, logger, slots, limiter) {
+		conn.Close()
+		return
+	}
+
+	if !s.track(conn) {
+		// Shutdown started between Accept and now
+		conn.Close()
+		if slots != nil {
+			<-slots
+		}
+		return
+	}
+
+	logger.Info("accepted connection", logging.LocalAddr(conn))
+	s.Metrics.Accepted.Add(1)
+	s.Metrics.Active.Add(1)
+	defer func() {
+		s.Metrics.Active.Add(-1)
+		if slots != nil {
+			<-slots
+		}
+		s.untrack(conn)
+	}()
+
+	if err := s.serveConn(conn, logger); err != nil {
+		s.Metrics.Failed.Add(1)
+		logger.Info("connection closed", "error", err)
+		if s.OnError != nil {
+			s.OnError(conn, err)
+		}
+		return
+	}
+	logger.Info("connection closed")
+}
+
+// serveConn runs the handler of conn. Its information is only gathered
+// when someone needs it: OnAccept, the log or the handler via ConnInfoOf.
+func (s *TcpServer) serveConn(conn net.Conn, logger *slog.Logger) error {
+	var once sync.Once
+	var info *ConnInfo
+	var infoErr error
+	lookup := func() (*ConnInfo, error) {
+		once.Do(func() { info, infoErr = s.inspect(conn) })
+		return info, infoErr
+	}
+	connInfos.Store(conn, lookup)
+	defer connInfos.Delete(conn)
+
+	if s.OnAccept != nil || logger.Enabled(context.Background(), slog.LevelInfo) {
+		info, err := lookup()
 		if err != nil {
+			conn.Close()
 			return err
 		}
+		if info.Hostname != "" || info.TLS != nil || info.ProxyAddr != nil {
+			logger.Info("peer identified", "hostname", info.Hostname, "tls_subject", info.ClientSubject(), "proxy", info.ProxyAddr)
+		}
+		if s.OnAccept != nil {
+			s.OnAccept(info)
+		}
+	}
+	return s.handle(conn, logger)
+}
+
+// handle runs the handler, recovering from a panic so a single connection
+// cannot take the whole server down
+func (s *TcpServer) handle(conn net.Conn, logger *slog.Logger) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.Metrics.Panics.Add(1)
+			perr := &PanicError{Value: v, Stack: debug.Stack()}
+			logger.Error("handler panicked", "panic", v, "stack", string(perr.Stack))
+			conn.Close()
+			err = perr
+		}
+	}()
+
+	return s.Handler(conn, s.Input, s.Output)
+}
+
+// Use wraps the current handler with middleware, see Chain for the order
+func (s *TcpServer) Use(middleware ...Middleware) {
+	s.Handler = Chain(s.Handler, middleware...)
+}
+
+// admit applies the access list, the per IP rate limit and the connection
+// limit to a new connection. When it returns true the connection holds a slot.
+func (s *TcpServer) admit(conn net.Conn, logger *slog.Logger, slots chan struct{}, limiter *ipRateLimiter) bool {
+	// In queue mode the slot was taken before accepting
+	queued := slots != nil && s.ConnPolicy == PolicyQueue
+	reject := func(counter *atomic.Int64, reason string) bool {
+		counter.Add(1)
+		logger.Warn("connection rejected", "reason", reason)
+		if queued {
+			<-slots
+		}
+		return false
+	}
+
+	if s.ACL != nil || s.TrustedProxies != nil || limiter != nil {
+		addrs, untrusted := s.checkedAddrs(conn)
+		if untrusted != nil {
+			return reject(&s.Metrics.RejectedACL, "untrusted proxy "+untrusted.String())
+		}
+
+		if s.ACL != nil {
+			for _, addr := range addrs {
+				allowed, reason := s.ACL.CheckAddr(addr)
+				if !allowed {
+					return reject(&s.Metrics.RejectedACL, reason)
+				}
+				logger.Debug("connection allowed", "reason", reason)
+			}
+		}
 
-		go s.Handler(conn, s.Input, s.Output)
+		// A client claiming a new source with every header still hits its limit
+		if limiter != nil && !limiter.allow(addrs[len(addrs)-1]) {
+			return reject(&s.Metrics.RejectedRate, "connection rate limit")
+		}
+	}
+
+	if slots != nil && !queued {
+		select {
+		case slots <- struct{}{}:
+		default:
+			return reject(&s.Metrics.RejectedMaxConns, "too many connections")
+		}
 	}
+
+	return true
 }
 
-// Close stops the server and closes the listener
-func (s *TcpServer) Close() error {
+// checkedAddrs returns the addresses the ACL and the rate limit apply to.
+// Behind a PROXY header the peer that sent it is checked too, unless it is a
+// trusted proxy: the header is written by the client and may be forged. A
+// peer on a Unix socket has no IP to check, the permissions of the socket
+// guard it. untrusted is the peer when TrustedProxies does not list it.
+func (s *TcpServer) checkedAddrs(conn net.Conn) (addrs []net.Addr, untrusted net.Addr) {
+	addrs = []net.Addr{conn.RemoteAddr()}
+	pc := proxyConn(conn)
+	if pc == nil {
+		return addrs, nil
+	}
+
+	peer := pc.ProxyAddr()
+	if s.TrustedProxies != nil {
+		if trusted, _ := s.TrustedProxies.CheckAddr(peer); !trusted {
+			return nil, peer
+		}
+		return addrs, nil
+	}
+	if _, local := peer.(*net.UnixAddr); !local {
+		addrs = append(addrs, peer)
+	}
+	return addrs, nil
+}
+
+// isTemporary reports whether an accept error is expected to go away, such
+// as EMFILE or ECONNABORTED
+func isTemporary(err error) bool {
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
+
+// nextRetryDelay doubles the previous delay within the retry bounds
+func nextRetryDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minRetryDelay
+	}
+	return min(2*delay, maxRetryDelay)
+}
+
+// track registers an active connection, it fails once shutdown started
+func (s *TcpServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+// untrack forgets a connection whose handler returned
+func (s *TcpServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.handlers.Done()
+}
+
+// doneChan returns the channel closed when shutdown starts
+func (s *TcpServer) doneChan() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.doneChanLocked()
+}
+
+func (s *TcpServer) doneChanLocked() chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	return s.done
+}
+
+func (s *TcpServer) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+var errNoListener = errors.New("listener not initialized")
+
+// stopAccepting closes the listener once and returns the number of active
+// connections
+func (s *TcpServer) stopAccepting() (int, error) {
 	if s.Listener == nil {
-		return errors.New("listener not initialized")
+		return 0, errNoListener
+	}
+
+	s.mu.Lock()
+	first := !s.closing
+	if first {
+		s.closing = true
+		close(s.doneChanLocked())
+	}
+	active := len(s.conns)
+	s.mu.Unlock()
+
+	if !first {
+		return active, nil
+	}
+	return active, s.Listener.Close()
+}
+
+// Shutdown stops accepting connections and waits for the active ones to
+// finish. When ctx expires first the remaining connections are closed and
+// the context error is returned.
+func (s *TcpServer) Shutdown(ctx context.Context) error {
+	active, err := s.stopAccepting()
+	if errors.Is(err, errNoListener) {
+		return err
+	}
+
+	logger := logging.OrDiscard(s.Logger)
+	if active > 0 {
+		logger.Info("draining connections", "active", active)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	forced := len(s.conns)
+	s.mu.Unlock()
+
+	if forced > 0 {
+		logger.Warn("closed connections still active after draining", "count", forced)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Close stops the server by closing the listener. Active connections are
+// left to finish on their own, unless DrainTimeout is set: then Close waits
+// up to DrainTimeout for them and closes the ones left.
+//
+// There is no default drain deadline on purpose: existing callers close the
+// server while their handlers keep serving, and a deadline would start
+// cutting those connections. Callers wanting a bounded shutdown set
+// DrainTimeout or use Shutdown with their own context.
+func (s *TcpServer) Close() error {
+	if s.DrainTimeout <= 0 {
+		_, err := s.stopAccepting()
+		return err
 	}
-	return s.Listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
 }
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gppmad/gonc/acl"
+	"github.com/gppmad/gonc/session"
 	tcp_server "github.com/gppmad/gonc/tcp_server"
 )
 
@@ -153,6 +155,16 @@ func (m *mockReader) Read(p []byte) (n int, err error) {
 	return 0, io.EOF // Return EOF after first call or if no error specified
 }
 
+// idleReader simulates a peer that stays silent until the connection is closed
+type idleReader struct {
+	closed chan struct{}
+}
+
+func (r idleReader) Read(p []byte) (n int, err error) {
+	<-r.closed
+	return 0, io.EOF
+}
+
 // mockWriter simulates a writer that always succeeds
 type mockWriter struct{}
 
@@ -164,10 +176,11 @@ func TestDefaultHandler(t *testing.T) {
 	// Test case 1: Error when copying from input to connection
 	t.Run("input copy error", func(t *testing.T) {
 		expectedErr := errors.New("input error")
+		closed := make(chan struct{})
 		mockConn := &mockConnRW{
-			reader: &mockReader{}, // Will return EOF
-			writer: &mockWriter{}, // Always succeeds
-			closed: make(chan struct{}),
+			reader: idleReader{closed}, // The peer stays, the input fails first
+			writer: &mockWriter{},      // Always succeeds
+			closed: closed,
 		}
 
 		// Create input that will error on first call and then EOF
@@ -175,8 +188,9 @@ func TestDefaultHandler(t *testing.T) {
 		output := &mockWriter{}
 
 		err := tcp_server.DefaultHandler(mockConn, input, output)
-		if err.Error() != expectedErr.Error() {
-			t.Errorf("expected error %v, got %v", expectedErr, err)
+		var serr *session.Error
+		if !errors.Is(err, expectedErr) || !errors.As(err, &serr) || serr.Direction != session.Send {
+			t.Errorf("expected a send error wrapping %v, got %v", expectedErr, err)
 		}
 	})
 
@@ -194,8 +208,78 @@ func TestDefaultHandler(t *testing.T) {
 		output := &mockWriter{}
 
 		err := tcp_server.DefaultHandler(mockConn, input, output)
-		if err.Error() != expectedErr.Error() {
-			t.Errorf("expected error %v, got %v", expectedErr, err)
+		var serr *session.Error
+		if !errors.Is(err, expectedErr) || !errors.As(err, &serr) || serr.Direction != session.Receive {
+			t.Errorf("expected a receive error wrapping %v, got %v", expectedErr, err)
 		}
 	})
 }
+
+// addrConn is a MockConn reporting a remote address
+type addrConn struct {
+	MockConn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *addrConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+}
+
+func TestACL(t *testing.T) {
+	connChan := make(chan net.Conn)
+	server := tcp_server.NewTcpServer(&MockListener{connections: connChan}, nil, nil)
+
+	list, err := acl.New([]string{"192.168.0.0/16", "fd00::/8"}, []string{"192.168.66.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.ACL = list
+
+	handled := make(chan net.Addr, 10)
+	server.Handler = func(conn net.Conn, input io.Reader, output io.Writer) error {
+		handled <- conn.RemoteAddr()
+		return conn.Close()
+	}
+	go server.Start()
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"192.168.1.10", true},
+		{"192.168.66.1", false}, // denied range inside the allowed one
+		{"10.0.0.1", false},
+		{"fd00::1", true},
+		{"2001:db8::1", false},
+	}
+
+	for _, tt := range tests {
+		conn := &addrConn{
+			MockConn: MockConn{closed: make(chan struct{})},
+			remote:   &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 40000},
+		}
+		connChan <- conn
+
+		// Every connection is closed, either by the ACL or by the handler
+		select {
+		case <-conn.closed:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("connection from %s was not closed", tt.ip)
+		}
+
+		select {
+		case <-handled:
+			if !tt.allowed {
+				t.Errorf("connection from %s should have been rejected", tt.ip)
+			}
+		default:
+			if tt.allowed {
+				t.Errorf("connection from %s should have been handled", tt.ip)
+			}
+		}
+	}
+}
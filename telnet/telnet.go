@@ -0,0 +1,380 @@
+package telnet
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Telnet commands (RFC 854)
+const (
+	SE   byte = 240 // End of subnegotiation parameters
+	NOP  byte = 241 // No operation
+	GA   byte = 249 // Go ahead
+	SB   byte = 250 // Start of subnegotiation
+	WILL byte = 251
+	WONT byte = 252
+	DO   byte = 253
+	DONT byte = 254
+	IAC  byte = 255 // Interpret as command
+)
+
+// Telnet options this package knows how to negotiate
+const (
+	OptEcho            byte = 1  // RFC 857
+	OptSuppressGoAhead byte = 3  // RFC 858
+	OptTerminalType    byte = 24 // RFC 1091
+	OptNAWS            byte = 31 // RFC 1073, negotiate about window size
+)
+
+// Terminal type subnegotiation codes (RFC 1091)
+const (
+	ttypeIs   byte = 0
+	ttypeSend byte = 1
+)
+
+// Options selects which telnet options are accepted during negotiation.
+// The zero value refuses every option.
+type Options struct {
+	// Echo lets the remote side take over echoing (WILL ECHO),
+	// together with suppress go ahead, as character-mode servers expect.
+	Echo bool
+
+	// TerminalType is reported when the server asks for it. Empty refuses the option.
+	TerminalType string
+
+	// Width and Height are reported through NAWS. Zero refuses the option.
+	Width  uint16
+	Height uint16
+}
+
+// Writer escapes outgoing data, translates it to NVT newlines and
+// serializes it with negotiation replies, so a reply never lands in the
+// middle of a data write.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns a Writer sending to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write doubles every IAC byte in p so the peer reads it as data, sends a
+// bare LF as CR LF and a bare CR as CR NUL (RFC 854). Every Write is
+// translated on its own, a CR ending p is a bare CR.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !needsEncoding(p) {
+		return w.w.Write(p)
+	}
+
+	if _, err := w.w.Write(encode(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// needsEncoding reports whether p holds an IAC, CR or LF
+func needsEncoding(p []byte) bool {
+	for _, b := range p {
+		if b == IAC || b == '\r' || b == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+// encode escapes IAC and translates newlines to NVT
+func encode(p []byte) []byte {
+	out := make([]byte, 0, len(p)+len(p)/8+2)
+	for i, b := range p {
+		switch {
+		case b == IAC:
+			out = append(out, IAC, IAC)
+		case b == '\n' && (i == 0 || p[i-1] != '\r'):
+			out = append(out, '\r', '\n')
+		case b == '\r' && (i == len(p)-1 || p[i+1] != '\n'):
+			out = append(out, '\r', 0)
+		default:
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// command writes a raw control sequence without escaping
+func (w *Writer) command(seq ...byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.w.Write(seq)
+	return err
+}
+
+// parser states
+const (
+	stateData = iota
+	stateIAC
+	stateVerb
+	stateSB
+	stateSBIAC
+	stateCR
+)
+
+// Reader strips the telnet control stream from r and answers option
+// negotiation through the paired Writer.
+type Reader struct {
+	r    io.Reader
+	w    *Writer
+	opts Options
+
+	buf   []byte
+	state int
+	verb  byte
+	sub   []byte
+
+	mu  sync.Mutex // guards the fields below, shared with SetWindowSize
+	us  [256]bool  // options enabled on our side
+	him [256]bool  // options enabled on the remote side
+}
+
+// NewReader returns a Reader parsing r and replying through w
+func NewReader(r io.Reader, w *Writer, opts Options) *Reader {
+	return &Reader{r: r, w: w, opts: opts}
+}
+
+// Read returns the data stream only, with all telnet commands removed
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+
+	for {
+		n, err := r.r.Read(buf)
+		out := 0
+		for _, b := range buf[:n] {
+			if r.parse(b) {
+				p[out] = b
+				out++
+			}
+		}
+
+		// A read made only of commands must not look like an empty read,
+		// an empty read of r is passed on
+		if out > 0 || n == 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// parse advances the state machine and reports whether b is data
+func (r *Reader) parse(b byte) bool {
+	switch r.state {
+	case stateCR:
+		// CR NUL is a bare carriage return on the wire
+		r.state = stateData
+		if b == 0 {
+			return false
+		}
+		return r.parse(b)
+
+	case stateData:
+		switch b {
+		case IAC:
+			r.state = stateIAC
+			return false
+		case '\r':
+			r.state = stateCR
+		}
+		return true
+
+	case stateIAC:
+		switch b {
+		case IAC:
+			r.state = stateData
+			return true
+		case WILL, WONT, DO, DONT:
+			r.verb = b
+			r.state = stateVerb
+		case SB:
+			r.sub = r.sub[:0]
+			r.state = stateSB
+		default:
+			// NOP, GA and the other one byte commands carry no data
+			r.state = stateData
+		}
+		return false
+
+	case stateVerb:
+		r.state = stateData
+		r.negotiate(r.verb, b)
+		return false
+
+	case stateSB:
+		if b == IAC {
+			r.state = stateSBIAC
+		} else {
+			r.sub = append(r.sub, b)
+		}
+		return false
+
+	case stateSBIAC:
+		switch b {
+		case SE:
+			r.state = stateData
+			r.subnegotiate(r.sub)
+		case IAC:
+			r.sub = append(r.sub, IAC)
+			r.state = stateSB
+		default:
+			// Malformed subnegotiation, drop it
+			r.state = stateData
+		}
+		return false
+	}
+	return false
+}
+
+// accepts reports whether the remote side may enable opt
+func (r *Reader) accepts(opt byte) bool {
+	switch opt {
+	case OptEcho, OptSuppressGoAhead:
+		return r.opts.Echo
+	}
+	return false
+}
+
+// supports reports whether we are willing to enable opt on our side
+func (r *Reader) supports(opt byte) bool {
+	switch opt {
+	case OptTerminalType:
+		return r.opts.TerminalType != ""
+	case OptNAWS:
+		return r.opts.Width > 0 && r.opts.Height > 0
+	}
+	return false
+}
+
+// negotiate answers a WILL/WONT/DO/DONT request. Replies are only sent when
+// the state of the option changes, which prevents negotiation loops.
+func (r *Reader) negotiate(verb, opt byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch verb {
+	case WILL:
+		if r.him[opt] {
+			return
+		}
+		if r.accepts(opt) {
+			r.him[opt] = true
+			r.w.command(IAC, DO, opt)
+		} else {
+			r.w.command(IAC, DONT, opt)
+		}
+	case WONT:
+		if r.him[opt] {
+			r.him[opt] = false
+			r.w.command(IAC, DONT, opt)
+		}
+	case DO:
+		if r.us[opt] {
+			return
+		}
+		if r.supports(opt) {
+			r.us[opt] = true
+			r.w.command(IAC, WILL, opt)
+			if opt == OptNAWS {
+				r.sendWindowSize()
+			}
+		} else {
+			r.w.command(IAC, WONT, opt)
+		}
+	case DONT:
+		if r.us[opt] {
+			r.us[opt] = false
+			r.w.command(IAC, WONT, opt)
+		}
+	}
+}
+
+// subnegotiate answers the subnegotiations we support
+func (r *Reader) subnegotiate(sub []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(sub) == 2 && sub[0] == OptTerminalType && sub[1] == ttypeSend && r.us[OptTerminalType] {
+		seq := []byte{IAC, SB, OptTerminalType, ttypeIs}
+		seq = append(seq, r.opts.TerminalType...)
+		seq = append(seq, IAC, SE)
+		r.w.command(seq...)
+	}
+}
+
+// sendWindowSize reports the current window size, callers must hold r.mu
+func (r *Reader) sendWindowSize() error {
+	var size [4]byte
+	binary.BigEndian.PutUint16(size[0:], r.opts.Width)
+	binary.BigEndian.PutUint16(size[2:], r.opts.Height)
+
+	seq := []byte{IAC, SB, OptNAWS}
+	for _, b := range size {
+		// A 255 in the size has to be escaped like data
+		if b == IAC {
+			seq = append(seq, IAC)
+		}
+		seq = append(seq, b)
+	}
+	seq = append(seq, IAC, SE)
+	return r.w.command(seq...)
+}
+
+// SetWindowSize updates the window size and reports it to the server when NAWS is active
+func (r *Reader) SetWindowSize(width, height uint16) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.opts.Width, r.opts.Height = width, height
+	if !r.us[OptNAWS] {
+		return nil
+	}
+	return r.sendWindowSize()
+}
+
+// Conn wraps a net.Conn speaking telnet: reads return data only and
+// writes are escaped.
+type Conn struct {
+	net.Conn
+	*Reader
+	w *Writer
+}
+
+// NewConn wraps conn with a telnet Reader/Writer pair
+func NewConn(conn net.Conn, opts Options) *Conn {
+	w := NewWriter(conn)
+	return &Conn{Conn: conn, Reader: NewReader(conn, w, opts), w: w}
+}
+
+// Read returns the data stream of the connection
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+// Write sends p as telnet data
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// NetConn returns the connection speaking telnet
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
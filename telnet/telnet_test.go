@@ -0,0 +1,205 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// readAll drains a Reader built on top of the given wire bytes and returns
+// the data stream and the replies sent back to the server.
+func readAll(t *testing.T, wire []byte, opts Options) (string, []byte) {
+	t.Helper()
+
+	replies := new(bytes.Buffer)
+	r := NewReader(bytes.NewReader(wire), NewWriter(replies), opts)
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	return string(data), replies.Bytes()
+}
+
+func TestReaderStripsCommands(t *testing.T) {
+	wire := []byte("hello")
+	wire = append(wire, IAC, NOP, IAC, GA)
+	wire = append(wire, " world"...)
+	wire = append(wire, IAC, IAC) // escaped 255 is data
+	wire = append(wire, IAC, SB, 99, 1, 2, IAC, SE)
+	wire = append(wire, "\r\x00\r\n"...)
+
+	data, replies := readAll(t, wire, Options{})
+
+	if want := "hello world\xff\r\r\n"; data != want {
+		t.Fatalf("expected data %q, got %q", want, data)
+	}
+	if len(replies) != 0 {
+		t.Fatalf("expected no replies, got %v", replies)
+	}
+}
+
+func TestReaderRefusesByDefault(t *testing.T) {
+	wire := []byte{
+		IAC, WILL, OptEcho,
+		IAC, DO, OptNAWS,
+		IAC, DO, OptTerminalType,
+		IAC, WONT, OptEcho, // already disabled, no reply
+		IAC, DONT, OptNAWS, // already disabled, no reply
+	}
+
+	_, replies := readAll(t, wire, Options{})
+
+	want := []byte{
+		IAC, DONT, OptEcho,
+		IAC, WONT, OptNAWS,
+		IAC, WONT, OptTerminalType,
+	}
+	if !bytes.Equal(replies, want) {
+		t.Fatalf("expected replies %v, got %v", want, replies)
+	}
+}
+
+func TestReaderAcceptsEnabledOptions(t *testing.T) {
+	wire := []byte{
+		IAC, WILL, OptEcho,
+		IAC, WILL, OptEcho, // repeated request, no reply
+		IAC, DO, OptNAWS,
+		IAC, DO, OptTerminalType,
+		IAC, SB, OptTerminalType, ttypeSend, IAC, SE,
+	}
+	opts := Options{Echo: true, TerminalType: "xterm", Width: 80, Height: 255}
+
+	_, replies := readAll(t, wire, opts)
+
+	want := []byte{IAC, DO, OptEcho}
+	want = append(want, IAC, WILL, OptNAWS)
+	want = append(want, IAC, SB, OptNAWS, 0, 80, 0, IAC, IAC, IAC, SE)
+	want = append(want, IAC, WILL, OptTerminalType)
+	want = append(want, IAC, SB, OptTerminalType, ttypeIs)
+	want = append(want, "xterm"...)
+	want = append(want, IAC, SE)
+	if !bytes.Equal(replies, want) {
+		t.Fatalf("expected replies %v, got %v", want, replies)
+	}
+}
+
+func TestReaderCommandSplitAcrossReads(t *testing.T) {
+	// One byte at a time forces the parser to keep its state between reads
+	wire := []byte{'a', IAC, WILL, OptEcho, 'b', IAC, IAC, 'c'}
+	replies := new(bytes.Buffer)
+	r := NewReader(io.MultiReader(bytesOneByOne(wire)...), NewWriter(replies), Options{})
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	if want := "ab\xffc"; string(data) != want {
+		t.Fatalf("expected data %q, got %q", want, data)
+	}
+	if want := []byte{IAC, DONT, OptEcho}; !bytes.Equal(replies.Bytes(), want) {
+		t.Fatalf("expected replies %v, got %v", want, replies.Bytes())
+	}
+}
+
+func bytesOneByOne(b []byte) []io.Reader {
+	readers := make([]io.Reader, len(b))
+	for i := range b {
+		readers[i] = bytes.NewReader(b[i : i+1])
+	}
+	return readers
+}
+
+func TestWriterEscapesIAC(t *testing.T) {
+	out := new(bytes.Buffer)
+	w := NewWriter(out)
+
+	n, err := w.Write([]byte{'a', IAC, 'b'})
+	if err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	// The caller must see the length of its own data, not the escaped one
+	if n != 3 {
+		t.Errorf("expected 3 bytes written, got %d", n)
+	}
+	if want := []byte{'a', IAC, IAC, 'b'}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("expected %v on the wire, got %v", want, out.Bytes())
+	}
+}
+
+func TestSetWindowSize(t *testing.T) {
+	replies := new(bytes.Buffer)
+	r := NewReader(bytes.NewReader([]byte{IAC, DO, OptNAWS}), NewWriter(replies), Options{Width: 80, Height: 24})
+
+	// Before NAWS is negotiated the size is only recorded
+	if err := r.SetWindowSize(100, 30); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replies.Len() != 0 {
+		t.Fatalf("expected no replies before negotiation, got %v", replies.Bytes())
+	}
+
+	io.ReadAll(r)
+	replies.Reset()
+
+	if err := r.SetWindowSize(120, 40); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []byte{IAC, SB, OptNAWS, 0, 120, 0, 40, IAC, SE}
+	if !bytes.Equal(replies.Bytes(), want) {
+		t.Fatalf("expected %v, got %v", want, replies.Bytes())
+	}
+}
+
+func TestWriterTranslatesNewlines(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"ls\n", "ls\r\n"},
+		{"a\r\nb", "a\r\nb"},
+		{"a\rb", "a\r\x00b"},
+		{"\r", "\r\x00"},
+		{"\n\n", "\r\n\r\n"},
+		{"\xff\n", "\xff\xff\r\n"},
+	}
+	for _, tt := range tests {
+		out := new(bytes.Buffer)
+		n, err := NewWriter(out).Write([]byte(tt.in))
+		if err != nil || n != len(tt.in) {
+			t.Errorf("Write(%q) = %d, %v", tt.in, n, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("Write(%q) sent %q, want %q", tt.in, out.String(), tt.want)
+		}
+	}
+}
+
+// emptyReader returns no data and no error, like a misbehaving connection
+type emptyReader struct{ reads int }
+
+func (r *emptyReader) Read(p []byte) (int, error) {
+	r.reads++
+	return 0, nil
+}
+
+func TestReaderEmptyReads(t *testing.T) {
+	src := &emptyReader{}
+	r := NewReader(src, NewWriter(io.Discard), Options{})
+
+	if n, err := r.Read(nil); n != 0 || err != nil {
+		t.Errorf("Read(nil) = %d, %v", n, err)
+	}
+	if src.reads != 0 {
+		t.Errorf("an empty buffer must not read, got %d reads", src.reads)
+	}
+
+	if n, err := r.Read(make([]byte, 16)); n != 0 || err != nil {
+		t.Errorf("Read = %d, %v", n, err)
+	}
+	if src.reads != 1 {
+		t.Errorf("expected an empty read to be passed on, got %d reads", src.reads)
+	}
+}
@@ -0,0 +1,12 @@
+package terminal
+
+import "errors"
+
+// ErrNotSupported is returned on platforms without termios support
+var ErrNotSupported = errors.New("raw terminal mode is not supported on this platform")
+
+// WindowSize is the size of a terminal in character cells
+type WindowSize struct {
+	Width  uint16
+	Height uint16
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package terminal
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// State holds the terminal attributes to restore after raw mode
+type State struct {
+	termios syscall.Termios
+}
+
+// winsize mirrors struct winsize from <sys/ioctl.h>
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal reports whether fd refers to a terminal
+func IsTerminal(fd int) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
+
+// MakeRaw puts the terminal into raw mode, like cfmakeraw(3): no line
+// buffering, no echo and no signal generation, so control characters such
+// as Ctrl-C are read as bytes. The returned State restores the terminal.
+func MakeRaw(fd int) (*State, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &State{termios: old}, nil
+}
+
+// Restore sets the terminal back to a state returned by MakeRaw
+func Restore(fd int, state *State) error {
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&state.termios))
+}
+
+// GetSize returns the size of the terminal
+func GetSize(fd int) (WindowSize, error) {
+	var ws winsize
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return WindowSize{}, err
+	}
+	return WindowSize{Width: ws.Col, Height: ws.Row}, nil
+}
+
+// NotifyResize calls fn with the new terminal size every time the window
+// changes (SIGWINCH) until the returned stop function is called.
+func NotifyResize(fd int, fn func(WindowSize)) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigChan, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-sigChan:
+				if size, err := GetSize(fd); err == nil {
+					fn(size)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
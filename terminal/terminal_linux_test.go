@@ -0,0 +1,150 @@
+//go:build linux
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPty opens a new pseudo terminal pair and returns the master and slave ends
+func openPty(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock int32
+	if err := ioctl(int(master.Fd()), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatalf("unlockpt failed: %v", err)
+	}
+
+	var ptyNum uint32
+	if err := ioctl(int(master.Fd()), syscall.TIOCGPTN, unsafe.Pointer(&ptyNum)); err != nil {
+		t.Fatalf("ptsname failed: %v", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", ptyNum), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("cannot open pty slave: %v", err)
+	}
+	t.Cleanup(func() { slave.Close() })
+
+	return master, slave
+}
+
+func getTermios(t *testing.T, fd int) syscall.Termios {
+	t.Helper()
+
+	var termios syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		t.Fatalf("TCGETS failed: %v", err)
+	}
+	return termios
+}
+
+func TestIsTerminal(t *testing.T) {
+	_, slave := openPty(t)
+
+	if !IsTerminal(int(slave.Fd())) {
+		t.Error("expected the pty slave to be a terminal")
+	}
+
+	file, err := os.CreateTemp(t.TempDir(), "notatty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if IsTerminal(int(file.Fd())) {
+		t.Error("expected a regular file not to be a terminal")
+	}
+}
+
+func TestMakeRawAndRestore(t *testing.T) {
+	_, slave := openPty(t)
+	fd := int(slave.Fd())
+
+	before := getTermios(t, fd)
+	if before.Lflag&syscall.ICANON == 0 {
+		t.Fatal("a new pty should start in canonical mode")
+	}
+
+	state, err := MakeRaw(fd)
+	if err != nil {
+		t.Fatalf("MakeRaw failed: %v", err)
+	}
+
+	raw := getTermios(t, fd)
+	if raw.Lflag&(syscall.ICANON|syscall.ECHO|syscall.ISIG) != 0 {
+		t.Errorf("expected ICANON, ECHO and ISIG to be off, got lflag %#x", raw.Lflag)
+	}
+	if raw.Oflag&syscall.OPOST != 0 {
+		t.Errorf("expected OPOST to be off, got oflag %#x", raw.Oflag)
+	}
+	if raw.Cc[syscall.VMIN] != 1 {
+		t.Errorf("expected VMIN 1, got %d", raw.Cc[syscall.VMIN])
+	}
+
+	if err := Restore(fd, state); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	after := getTermios(t, fd)
+	if after.Lflag != before.Lflag || after.Iflag != before.Iflag || after.Oflag != before.Oflag {
+		t.Errorf("terminal not restored: before %+v, after %+v", before, after)
+	}
+}
+
+func TestRawModeForwardsControlCharacters(t *testing.T) {
+	master, slave := openPty(t)
+
+	state, err := MakeRaw(int(slave.Fd()))
+	if err != nil {
+		t.Fatalf("MakeRaw failed: %v", err)
+	}
+	defer Restore(int(slave.Fd()), state)
+
+	// Ctrl-C and Ctrl-D must reach the reader as plain bytes, without a newline
+	if _, err := master.Write([]byte{0x03, 0x04}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2)
+	n, err := slave.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 1 {
+		_, err = slave.Read(buf[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf[0] != 0x03 || buf[1] != 0x04 {
+		t.Errorf("expected control characters to be forwarded, got %v", buf)
+	}
+}
+
+func TestGetSize(t *testing.T) {
+	master, slave := openPty(t)
+
+	ws := winsize{Row: 24, Col: 80}
+	if err := ioctl(int(master.Fd()), syscall.TIOCSWINSZ, unsafe.Pointer(&ws)); err != nil {
+		t.Fatalf("TIOCSWINSZ failed: %v", err)
+	}
+
+	size, err := GetSize(int(slave.Fd()))
+	if err != nil {
+		t.Fatalf("GetSize failed: %v", err)
+	}
+	if size != (WindowSize{Width: 80, Height: 24}) {
+		t.Errorf("expected 80x24, got %dx%d", size.Width, size.Height)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package terminal
+
+// State holds the terminal attributes to restore after raw mode
+type State struct{}
+
+// IsTerminal reports whether fd refers to a terminal
+func IsTerminal(fd int) bool {
+	return false
+}
+
+// MakeRaw is not supported on this platform
+func MakeRaw(fd int) (*State, error) {
+	return nil, ErrNotSupported
+}
+
+// Restore is not supported on this platform
+func Restore(fd int, state *State) error {
+	return ErrNotSupported
+}
+
+// GetSize is not supported on this platform
+func GetSize(fd int) (WindowSize, error) {
+	return WindowSize{}, ErrNotSupported
+}
+
+// NotifyResize does nothing on this platform
+func NotifyResize(fd int, fn func(WindowSize)) (stop func()) {
+	return func() {}
+}
@@ -1,70 +1,40 @@
 package tls_client
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net"
-	"os"
-)
-
-var tlsDial = tls.Dial
-
-type TlsClient struct {
-	Input  io.Reader
-	Output io.Writer
-	Conn   net.Conn
-}
-
-func NewTlsClient(conn net.Conn, input io.Reader, output io.Writer) *TlsClient {
-	if input == nil {
-		input = os.Stdin
-	}
-
-	if output == nil {
-		output = os.Stdout
-	}
 
-	return &TlsClient{Conn: conn, Input: input, Output: output}
-}
-
-// Before call this server initialize the connection with Connect()
-func (c *TlsClient) Start() error {
-	if c.Conn == nil {
-		return errors.New("connect to the target before initialize a new connection")
-	}
-
-	errChan := make(chan error, 1)
-
-	// Read from the connection.
-	go func() {
-		_, err := io.Copy(c.Output, c.Conn)
-		if err != nil {
-			errChan <- err
-			return
-		}
-		errChan <- nil
-	}()
+	"github.com/gppmad/gonc/session"
+)
 
-	_, err := io.Copy(c.Conn, c.Input)
+// tlsDialContext dials and completes the handshake, tests replace it
+var tlsDialContext = func(ctx context.Context, network, address string, config *tls.Config) (*tls.Conn, error) {
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
-		return errors.New("error writing in the connection")
+		return nil, err
 	}
-
-	// Check errors from during connection reading.
-	if err := <-errChan; err != nil {
-		return errors.New("error reading from the connection: " + err.Error())
-	}
-	return nil
+	return conn.(*tls.Conn), nil
 }
 
-// Close the connection
-func (c *TlsClient) Close() error {
-	return c.Conn.Close()
+// TlsClient is a session over a TLS connection, see Connect
+type TlsClient = session.Session
+
+func NewTlsClient(conn net.Conn, input io.Reader, output io.Writer) *TlsClient {
+	return session.New(conn, input, output)
 }
 
 // Helper function to establish a TLS connection
 func Connect(address string, config *tls.Config) (*tls.Conn, error) {
+	return ConnectContext(context.Background(), address, config)
+}
+
+// ConnectContext is like Connect but gives up dialing or the handshake when
+// ctx is done
+func ConnectContext(ctx context.Context, address string, config *tls.Config) (*tls.Conn, error) {
 	if config == nil {
 		config = &tls.Config{
 			InsecureSkipVerify: false,
@@ -80,5 +50,5 @@ func Connect(address string, config *tls.Config) (*tls.Conn, error) {
 		config.ServerName = host
 	}
 
-	return tlsDial("tcp", address, config)
+	return tlsDialContext(ctx, "tcp", address, config)
 }
@@ -2,6 +2,7 @@ package tls_client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
@@ -178,11 +179,11 @@ func TestTlsClientClose(t *testing.T) {
 }
 
 func TestConnect(t *testing.T) {
-	originalTLSDial := tlsDial
-	defer func() { tlsDial = originalTLSDial }()
+	originalTLSDial := tlsDialContext
+	defer func() { tlsDialContext = originalTLSDial }()
 
 	t.Run("successful connection", func(t *testing.T) {
-		tlsDial = func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+		tlsDialContext = func(ctx context.Context, network, addr string, config *tls.Config) (*tls.Conn, error) {
 			return &tls.Conn{}, nil
 		}
 
@@ -196,7 +197,7 @@ func TestConnect(t *testing.T) {
 	})
 
 	t.Run("failed connection", func(t *testing.T) {
-		tlsDial = func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+		tlsDialContext = func(ctx context.Context, network, addr string, config *tls.Config) (*tls.Conn, error) {
 			return nil, errors.New("mock connection error")
 		}
 
@@ -209,3 +210,30 @@ func TestConnect(t *testing.T) {
 		}
 	})
 }
+
+func TestConnectContextHandshakeTimeout(t *testing.T) {
+	// The peer accepts the TCP connection but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	conn, err := ConnectContext(ctx, listener.Addr().String(), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the handshake to hit the deadline, got %v", err)
+	}
+	if conn != nil {
+		t.Error("Expected nil connection, got connection")
+	}
+}
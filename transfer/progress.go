@@ -0,0 +1,75 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gppmad/gonc/internal/units"
+)
+
+// progressInterval limits how often the progress line is redrawn
+const progressInterval = 200 * time.Millisecond
+
+// Progress is an io.Writer counting the bytes written through it and
+// drawing a progress line with the current throughput.
+type Progress struct {
+	w     io.Writer
+	name  string
+	total int64
+	done  int64
+
+	now   func() time.Time
+	start time.Time
+	drawn time.Time
+}
+
+// NewProgress reports the transfer of total bytes named name to w.
+// A nil w disables the output but still counts the bytes.
+func NewProgress(w io.Writer, name string, total int64) *Progress {
+	p := &Progress{w: w, name: name, total: total, now: time.Now}
+	p.start = p.now()
+	return p
+}
+
+// Write counts p and redraws the progress line when it is due
+func (p *Progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if now := p.now(); now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		p.draw("\r")
+	}
+	return len(b), nil
+}
+
+// Written returns the number of bytes counted so far
+func (p *Progress) Written() int64 {
+	return p.done
+}
+
+// Finish draws the final progress line
+func (p *Progress) Finish() {
+	p.draw("\r")
+	if p.w != nil {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *Progress) draw(prefix string) {
+	if p.w == nil {
+		return
+	}
+
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(p.done) * 100 / float64(p.total)
+	}
+
+	rate := 0.0
+	if elapsed := p.now().Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.done) / elapsed
+	}
+
+	fmt.Fprintf(p.w, "%s%s %s / %s (%.0f%%) %s/s", prefix, p.name,
+		units.FormatBytes(float64(p.done)), units.FormatBytes(float64(p.total)), percent, units.FormatBytes(rate))
+}
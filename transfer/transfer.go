@@ -0,0 +1,478 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// magic starts every transfer header, followed by the protocol version
+var magic = []byte("GONC")
+
+const version = 1
+
+// Kind is the type of payload following a header
+type Kind byte
+
+const (
+	KindFile Kind = 'f' // A single regular file
+	KindDir  Kind = 'd' // A directory, sent as a tar archive
+)
+
+var (
+	ErrBadHeader        = errors.New("invalid transfer header")
+	ErrChecksumMismatch = errors.New("sha256 checksum mismatch")
+)
+
+// Header describes the payload that follows it on the connection
+type Header struct {
+	Kind Kind
+	Name string
+	Size int64
+	Mode fs.FileMode
+	Sum  [sha256.Size]byte
+}
+
+// WriteHeader encodes h to w:
+// magic, version, kind, mode (uint32), size (uint64), sha256, name length (uint16), name
+func WriteHeader(w io.Writer, h Header) error {
+	if len(h.Name) == 0 || len(h.Name) > 0xffff {
+		return ErrBadHeader
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(magic)
+	buf.WriteByte(version)
+	buf.WriteByte(byte(h.Kind))
+	binary.Write(buf, binary.BigEndian, uint32(h.Mode))
+	binary.Write(buf, binary.BigEndian, uint64(h.Size))
+	buf.Write(h.Sum[:])
+	binary.Write(buf, binary.BigEndian, uint16(len(h.Name)))
+	buf.WriteString(h.Name)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadHeader decodes a header written by WriteHeader
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+
+	fixed := make([]byte, len(magic)+2+4+8+sha256.Size+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return h, fmt.Errorf("error reading transfer header: %w", err)
+	}
+	if !bytes.Equal(fixed[:len(magic)], magic) || fixed[len(magic)] != version {
+		return h, ErrBadHeader
+	}
+
+	rest := fixed[len(magic)+1:]
+	h.Kind = Kind(rest[0])
+	h.Mode = fs.FileMode(binary.BigEndian.Uint32(rest[1:5]))
+	h.Size = int64(binary.BigEndian.Uint64(rest[5:13]))
+	copy(h.Sum[:], rest[13:13+sha256.Size])
+	nameLen := binary.BigEndian.Uint16(rest[13+sha256.Size:])
+
+	if h.Kind != KindFile && h.Kind != KindDir || h.Size < 0 || nameLen == 0 {
+		return h, ErrBadHeader
+	}
+
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return h, fmt.Errorf("error reading transfer header: %w", err)
+	}
+	h.Name = string(name)
+
+	return h, nil
+}
+
+// Sender sends a file or a directory over a connection. It implements the
+// same Start/Close operations as the other network clients.
+type Sender struct {
+	Conn     net.Conn
+	Path     string
+	Progress io.Writer // Where progress is reported, nil disables it
+}
+
+// NewSender creates a Sender for path over conn
+func NewSender(conn net.Conn, path string, progress io.Writer) *Sender {
+	return &Sender{Conn: conn, Path: path, Progress: progress}
+}
+
+// Start sends the header and the payload, then waits for the receiver to
+// confirm the checksum
+func (s *Sender) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext is like Start but aborts the transfer, closing the
+// connection, when ctx is done
+func (s *Sender) StartContext(ctx context.Context) error {
+	if s.Conn == nil {
+		return errors.New("connect to the target before initialize a new connection")
+	}
+
+	stop := context.AfterFunc(ctx, func() { s.Conn.Close() })
+	defer stop()
+
+	err := s.send()
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// send transfers the payload and checks the receiver status
+func (s *Sender) send() error {
+	payload, h, err := prepare(s.Path)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+
+	if err := WriteHeader(s.Conn, h); err != nil {
+		return fmt.Errorf("error writing in the connection: %w", err)
+	}
+
+	progress := NewProgress(s.Progress, h.Name, h.Size)
+	if _, err := io.Copy(io.MultiWriter(s.Conn, progress), payload); err != nil {
+		return fmt.Errorf("error writing in the connection: %w", err)
+	}
+	progress.Finish()
+
+	// The receiver answers with a single status line
+	status, err := bufio.NewReader(s.Conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading the transfer status: %w", err)
+	}
+	status = strings.TrimSpace(status)
+	if status != "OK" {
+		return fmt.Errorf("transfer rejected by the receiver: %s", strings.TrimPrefix(status, "ERR "))
+	}
+
+	if s.Progress != nil {
+		fmt.Fprintf(s.Progress, "Sent %s (%d bytes, sha256 %x verified)\n", h.Name, h.Size, h.Sum)
+	}
+	return nil
+}
+
+// Close the connection
+func (s *Sender) Close() error {
+	return s.Conn.Close()
+}
+
+// prepare opens the payload for path and computes its header. Directories
+// are archived into a temporary tar file first so size and checksum are known
+// before the payload is sent.
+func prepare(path string) (io.ReadCloser, Header, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	h := Header{Name: filepath.Base(filepath.Clean(path)), Mode: info.Mode().Perm()}
+
+	var payload io.ReadSeekCloser
+	if info.IsDir() {
+		h.Kind = KindDir
+		payload, err = archive(path)
+	} else if info.Mode().IsRegular() {
+		h.Kind = KindFile
+		payload, err = os.Open(path)
+	} else {
+		return nil, h, fmt.Errorf("%s is not a regular file or a directory", path)
+	}
+	if err != nil {
+		return nil, h, err
+	}
+
+	hash := sha256.New()
+	h.Size, err = io.Copy(hash, payload)
+	if err == nil {
+		_, err = payload.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		payload.Close()
+		return nil, h, err
+	}
+	copy(h.Sum[:], hash.Sum(nil))
+
+	return payload, h, nil
+}
+
+// tempFile removes its backing file once closed
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// archive writes the content of dir into a temporary tar file, removed once
+// closed. It is staged next to dir, on the same file system as the data,
+// or in the system temporary directory when that one is not writable.
+func archive(dir string) (io.ReadSeekCloser, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	file, err := stage(filepath.Dir(dir), os.TempDir())
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(file)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Sending / stages the archive inside dir itself
+		if path == file.Name() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Only regular files and directories are transferred
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(tw, src)
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tempFile{file}.Close()
+		return nil, err
+	}
+
+	return tempFile{file}, nil
+}
+
+// stage creates the temporary archive in the first of dirs that accepts it.
+// Where open files can be removed it is unlinked right away, so nothing is
+// left behind even if the process dies.
+func stage(dirs ...string) (*os.File, error) {
+	var err error
+	for _, dir := range dirs {
+		var file *os.File
+		if file, err = os.CreateTemp(dir, ".gonc-send-*.tar"); err == nil {
+			os.Remove(file.Name())
+			return file, nil
+		}
+	}
+	return nil, err
+}
+
+// Receive reads a transfer from conn into dir. The payload is stored in a
+// temporary file and only moved into place once its checksum matches the
+// header. Existing files and directories are never overwritten. The sender
+// is told the outcome with a status line.
+func Receive(conn io.ReadWriter, dir string, progress io.Writer) (Header, error) {
+	h, err := ReadHeader(conn)
+	if err != nil {
+		return h, err
+	}
+
+	err = receive(conn, dir, h, progress)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %v\n", err)
+		return h, err
+	}
+
+	_, err = io.WriteString(conn, "OK\n")
+	return h, err
+}
+
+func receive(r io.Reader, dir string, h Header, progress io.Writer) error {
+	// Never trust a path coming from the network
+	name := filepath.Base(filepath.Clean("/" + h.Name))
+	if name == "/" || name == "." || name == ".." {
+		return ErrBadHeader
+	}
+
+	tmp, err := os.CreateTemp(dir, ".gonc-recv-*")
+	if err != nil {
+		return err
+	}
+	defer tempFile{tmp}.Close()
+
+	hash := sha256.New()
+	bar := NewProgress(progress, name, h.Size)
+	n, err := io.Copy(io.MultiWriter(tmp, hash, bar), io.LimitReader(r, h.Size))
+	if err != nil {
+		return err
+	}
+	if n != h.Size {
+		return fmt.Errorf("received %d of %d bytes: %w", n, h.Size, io.ErrUnexpectedEOF)
+	}
+	bar.Finish()
+
+	if !bytes.Equal(hash.Sum(nil), h.Sum[:]) {
+		return ErrChecksumMismatch
+	}
+
+	target := filepath.Join(dir, name)
+	if h.Kind == KindDir {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := os.Mkdir(target, h.Mode|0700); err != nil {
+			return refuseExisting(name, err)
+		}
+		// The directory was created above, a partial one is removed
+		if err := extract(tmp, target); err != nil {
+			os.RemoveAll(target)
+			return err
+		}
+		return nil
+	}
+
+	if err := tmp.Chmod(h.Mode.Perm()); err != nil {
+		return err
+	}
+	return refuseExisting(name, publish(tmp, target, h.Mode.Perm()))
+}
+
+// link is os.Link, replaceable in tests
+var link = os.Link
+
+// publish puts the verified temporary file at target, failing when target
+// exists. Unlike a rename a hard link never replaces a file. File systems
+// without hard links, such as FAT or some network mounts, get a copy
+// instead. The temporary name is removed by the caller.
+func publish(tmp *os.File, target string, mode fs.FileMode) error {
+	err := link(tmp.Name(), target)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writeFile(target, tmp, mode)
+}
+
+// refuseExisting explains an error caused by name existing in the destination
+func refuseExisting(name string, err error) error {
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("refusing to overwrite %s: %w", name, fs.ErrExist)
+	}
+	return err
+}
+
+// extract unpacks a tar archive into root, refusing entries escaping it
+func extract(r io.Reader, root string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		rel, err := filepath.Rel(root, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q escapes the destination", hdr.Name)
+		}
+
+		mode := fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile creates path, which must not exist, with the content of r. A
+// partial file is removed.
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// ReceiveHandler returns a server connection handler storing incoming
+// transfers in dir and reporting progress to progress.
+func ReceiveHandler(dir string, progress io.Writer) func(conn net.Conn, input io.Reader, output io.Writer) error {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		defer conn.Close()
+
+		h, err := Receive(conn, dir, progress)
+		if err != nil {
+			if progress != nil {
+				fmt.Fprintf(progress, "Transfer from %s failed: %v\n", conn.RemoteAddr(), err)
+			}
+			return err
+		}
+
+		if progress != nil {
+			fmt.Fprintf(progress, "Received %s (%d bytes, sha256 %x verified)\n", h.Name, h.Size, h.Sum)
+		}
+		return nil
+	}
+}
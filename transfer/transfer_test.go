@@ -0,0 +1,333 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run sends path through an in-memory connection and receives it into dir
+func run(t *testing.T, path, dir string) (Header, error, error) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	type result struct {
+		h   Header
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		h, err := Receive(serverConn, dir, nil)
+		done <- result{h, err}
+	}()
+
+	sendErr := NewSender(clientConn, path, nil).Start()
+	res := <-done
+	return res.h, sendErr, res.err
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	want := Header{Kind: KindFile, Name: "build.tar.gz", Size: 1234, Mode: 0640}
+	want.Sum = sha256.Sum256([]byte("payload"))
+
+	buf := new(bytes.Buffer)
+	if err := WriteHeader(buf, want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := ReadHeader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestReadHeaderRejectsGarbage(t *testing.T) {
+	garbage := bytes.NewBufferString(strings.Repeat("x", 64))
+	if _, err := ReadHeader(garbage); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("expected ErrBadHeader, got %v", err)
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "artifact.bin")
+	content := bytes.Repeat([]byte("gonc"), 10000)
+	if err := os.WriteFile(src, content, 0750); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+
+	h, sendErr, recvErr := run(t, src, dst)
+	if sendErr != nil || recvErr != nil {
+		t.Fatalf("unexpected errors: send %v, receive %v", sendErr, recvErr)
+	}
+
+	if h.Kind != KindFile || h.Size != int64(len(content)) {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "artifact.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("received content differs from the original")
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "artifact.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("expected mode 0750, got %v", info.Mode().Perm())
+	}
+}
+
+func TestSendDirectory(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "release")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(src, "README"), []byte("readme"), 0644)
+	os.WriteFile(filepath.Join(src, "bin", "gonc"), []byte("binary"), 0755)
+	dst := t.TempDir()
+
+	h, sendErr, recvErr := run(t, src, dst)
+	if sendErr != nil || recvErr != nil {
+		t.Fatalf("unexpected errors: send %v, receive %v", sendErr, recvErr)
+	}
+	if h.Kind != KindDir || h.Name != "release" {
+		t.Errorf("unexpected header %+v", h)
+	}
+
+	for path, want := range map[string]string{"README": "readme", "bin/gonc": "binary"} {
+		got, err := os.ReadFile(filepath.Join(dst, "release", path))
+		if err != nil {
+			t.Errorf("missing %s: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("expected %s to contain %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestReceiveChecksumMismatch(t *testing.T) {
+	dst := t.TempDir()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Receive(serverConn, dst, nil)
+		serverConn.Close()
+		done <- err
+	}()
+
+	// Header announcing a checksum that does not match the payload
+	h := Header{Kind: KindFile, Name: "bad.bin", Size: 4, Mode: 0644}
+	h.Sum = sha256.Sum256([]byte("good"))
+	WriteHeader(clientConn, h)
+	clientConn.Write([]byte("evil"))
+
+	status := make([]byte, 64)
+	n, _ := clientConn.Read(status)
+	if !strings.HasPrefix(string(status[:n]), "ERR ") {
+		t.Errorf("expected an error status, got %q", status[:n])
+	}
+
+	if err := <-done; !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// Nothing must be left behind, not even the temporary file
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 0 {
+		t.Errorf("expected an empty destination, found %d entries", len(entries))
+	}
+}
+
+func TestReceiveSanitizesName(t *testing.T) {
+	dst := t.TempDir()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go Receive(serverConn, dst, nil)
+
+	h := Header{Kind: KindFile, Name: "../../etc/passwd", Size: 2, Mode: 0644}
+	h.Sum = sha256.Sum256([]byte("ok"))
+	WriteHeader(clientConn, h)
+	clientConn.Write([]byte("ok"))
+
+	status := make([]byte, 64)
+	n, _ := clientConn.Read(status)
+	if string(status[:n]) != "OK\n" {
+		t.Fatalf("expected OK, got %q", status[:n])
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "passwd")); err != nil {
+		t.Errorf("expected the file to land inside the destination: %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	out := new(bytes.Buffer)
+	clock := time.Unix(0, 0)
+	p := NewProgress(out, "file", 2048)
+	p.now = func() time.Time { return clock }
+	p.start = clock
+
+	clock = clock.Add(time.Second)
+	p.Write(make([]byte, 1024))
+	p.Finish()
+
+	if p.Written() != 1024 {
+		t.Errorf("expected 1024 bytes counted, got %d", p.Written())
+	}
+	if want := "file 1.0 KiB / 2.0 KiB (50%) 1.0 KiB/s"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected progress to contain %q, got %q", want, out.String())
+	}
+}
+
+func TestReceiveRefusesToOverwrite(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(src, []byte("new"), 0644)
+	dst := t.TempDir()
+	os.WriteFile(filepath.Join(dst, "notes.txt"), []byte("old"), 0644)
+
+	_, sendErr, recvErr := run(t, src, dst)
+	if !errors.Is(recvErr, os.ErrExist) {
+		t.Fatalf("expected the receiver to refuse, got %v", recvErr)
+	}
+	if sendErr == nil || !strings.Contains(sendErr.Error(), "refusing to overwrite") {
+		t.Errorf("expected the sender to learn why, got %v", sendErr)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "notes.txt")); string(got) != "old" {
+		t.Errorf("expected the existing file untouched, got %q", got)
+	}
+
+	// Directories are not merged into existing ones either
+	dir := filepath.Join(t.TempDir(), "release")
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0644)
+	os.Mkdir(filepath.Join(dst, "release"), 0755)
+	if _, _, recvErr := run(t, dir, dst); !errors.Is(recvErr, os.ErrExist) {
+		t.Fatalf("expected the receiver to refuse the directory, got %v", recvErr)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "release", "README")); err == nil {
+		t.Error("expected nothing extracted into the existing directory")
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 2 {
+		t.Errorf("expected no temporary files left behind, found %d entries", len(entries))
+	}
+}
+
+// The archive of a directory is staged next to it, in the system temporary
+// directory when that fails, and never outlives the transfer
+func TestArchiveStaging(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "release")
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0644)
+
+	payload, err := archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := payload.(tempFile).Name()
+	if filepath.Dir(staged) != parent {
+		t.Errorf("expected the archive in %s, got %s", parent, staged)
+	}
+	payload.Close()
+	if _, err := os.Stat(staged); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the archive removed once closed, got %v", err)
+	}
+
+	fallback := t.TempDir()
+	file, err := stage(filepath.Join(parent, "missing"), fallback)
+	if err != nil {
+		t.Fatalf("expected the second directory to be used: %v", err)
+	}
+	defer tempFile{file}.Close()
+	if filepath.Dir(file.Name()) != fallback {
+		t.Errorf("expected the archive in %s, got %s", fallback, file.Name())
+	}
+}
+
+// Without hard links the file is copied into place, still never replacing
+// an existing one
+func TestReceiveWithoutHardLinks(t *testing.T) {
+	link = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	defer func() { link = os.Link }()
+
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(src, []byte("new"), 0640)
+	dst := t.TempDir()
+
+	if _, sendErr, recvErr := run(t, src, dst); sendErr != nil || recvErr != nil {
+		t.Fatalf("unexpected errors: send %v, receive %v", sendErr, recvErr)
+	}
+	info, err := os.Stat(filepath.Join(dst, "notes.txt"))
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("expected the file copied with mode 0640, got %v, %v", info, err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "notes.txt")); string(got) != "new" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	if _, _, recvErr := run(t, src, dst); !errors.Is(recvErr, os.ErrExist) {
+		t.Errorf("expected the copy to refuse an existing file, got %v", recvErr)
+	}
+}
+
+// A directory whose archive fails half way is not left behind
+func TestReceiveRemovesPartialDirectory(t *testing.T) {
+	var payload bytes.Buffer
+	tw := tar.NewWriter(&payload)
+	tw.WriteHeader(&tar.Header{Name: "README", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("ok"))
+	tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("no"))
+	tw.Close()
+
+	dst := t.TempDir()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := Receive(serverConn, dst, nil)
+		serverConn.Close()
+		done <- err
+	}()
+
+	h := Header{Kind: KindDir, Name: "release", Size: int64(payload.Len()), Mode: 0755}
+	h.Sum = sha256.Sum256(payload.Bytes())
+	WriteHeader(clientConn, h)
+	clientConn.Write(payload.Bytes())
+	io.Copy(io.Discard, clientConn)
+
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Fatalf("expected the escaping entry to fail, got %v", err)
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 0 {
+		t.Errorf("expected an empty destination, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,142 @@
+package udp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/gppmad/gonc/logging"
+)
+
+// MaxDatagram is the largest UDP payload, the default receive size
+const MaxDatagram = 65535
+
+// Format is how received datagrams are written out
+type Format int
+
+const (
+	// FormatRaw writes the payloads as they are
+	FormatRaw Format = iota
+	// FormatHex writes a hex dump of every datagram
+	FormatHex
+	// FormatLine writes one line per datagram: time, sender, size and the
+	// quoted payload
+	FormatLine
+)
+
+// ParseFormat parses raw, hex or line
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "", "raw":
+		return FormatRaw, nil
+	case "hex":
+		return FormatHex, nil
+	case "line":
+		return FormatLine, nil
+	}
+	return 0, fmt.Errorf("invalid format %q, expected raw, hex or line", s)
+}
+
+// Printer writes received datagrams to Output
+type Printer struct {
+	Output io.Writer
+	Format Format
+
+	// MaxSize is the largest datagram read, longer ones are truncated with
+	// a warning. MaxDatagram when 0.
+	MaxSize int
+
+	// Senders, when set, receives a line naming the sender of every
+	// datagram in the raw and hex formats, the line format has it already
+	Senders io.Writer
+
+	// Logger receives a warning for every truncated datagram, nil discards them
+	Logger *slog.Logger
+}
+
+// Print writes one datagram received from from, truncated tells it was cut
+// to MaxSize
+func (p *Printer) Print(datagram []byte, from net.Addr, truncated bool) error {
+	if truncated {
+		logging.OrDiscard(p.Logger).Warn("datagram truncated", "from", from, "max", p.maxSize())
+	}
+	if p.Senders != nil && p.Format != FormatLine {
+		fmt.Fprintf(p.Senders, "Datagram from %s, %d bytes\n", from, len(datagram))
+	}
+
+	var err error
+	switch p.Format {
+	case FormatHex:
+		_, err = io.WriteString(p.Output, hex.Dump(datagram))
+	case FormatLine:
+		mark := ""
+		if truncated {
+			mark = " truncated"
+		}
+		_, err = fmt.Fprintf(p.Output, "%s %s %d%s %q\n", time.Now().Format(time.RFC3339Nano), from, len(datagram), mark, datagram)
+	default:
+		_, err = p.Output.Write(datagram)
+	}
+	return err
+}
+
+func (p *Printer) maxSize() int {
+	if p.MaxSize > 0 {
+		return p.MaxSize
+	}
+	return MaxDatagram
+}
+
+// Writer returns a writer printing every Write as a datagram from from. It
+// reads whole datagrams when io.Copy hands it a connected UDP socket.
+func (p *Printer) Writer(from net.Addr) io.Writer {
+	return &datagramWriter{p: p, from: from}
+}
+
+type datagramWriter struct {
+	p    *Printer
+	from net.Addr
+}
+
+func (w *datagramWriter) Write(datagram []byte) (int, error) {
+	if err := w.p.Print(datagram, w.from, false); err != nil {
+		return 0, err
+	}
+	return len(datagram), nil
+}
+
+// ReadFrom reads one datagram per Read with a buffer one byte larger than
+// MaxSize, a datagram filling it was truncated. An empty read without an
+// error is an empty datagram.
+func (w *datagramWriter) ReadFrom(r io.Reader) (int64, error) {
+	size := w.p.maxSize()
+	buf := make([]byte, size+1)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 || err == nil {
+			total += int64(n)
+			if perr := w.p.Print(buf[:min(n, size)], w.from, n > size); perr != nil {
+				return total, perr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return total, nil
+			}
+			return total, err
+		}
+	}
+}
+
+// Handler prints what every connection of a UDP listener receives with p
+// instead of copying it to the shared output
+func Handler(p *Printer, next func(conn net.Conn, input io.Reader, output io.Writer) error) func(conn net.Conn, input io.Reader, output io.Writer) error {
+	return func(conn net.Conn, input io.Reader, output io.Writer) error {
+		return next(conn, input, p.Writer(conn.RemoteAddr()))
+	}
+}
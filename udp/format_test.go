@@ -0,0 +1,135 @@
+package udp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": FormatRaw, "raw": FormatRaw, "hex": FormatHex, "line": FormatLine} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("json"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestPrinterFormats(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	datagram := []byte("hi\x00\n")
+
+	var out, senders bytes.Buffer
+	p := &Printer{Output: &out, Format: FormatHex, Senders: &senders}
+	if err := p.Print(datagram, from, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != hex.Dump(datagram) {
+		t.Errorf("expected a hex dump, got %q", out.String())
+	}
+	if senders.String() != "Datagram from 192.0.2.1:53, 4 bytes\n" {
+		t.Errorf("expected the sender, got %q", senders.String())
+	}
+
+	out.Reset()
+	senders.Reset()
+	p.Format = FormatLine
+	if err := p.Print(datagram, from, true); err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) != 5 || fields[1] != "192.0.2.1:53" || fields[2] != "4" || fields[3] != "truncated" || fields[4] != `"hi\x00\n"` {
+		t.Errorf("unexpected line %q", out.String())
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		t.Errorf("expected a timestamp first: %v", err)
+	}
+	if senders.Len() != 0 {
+		t.Errorf("the line format carries the sender itself, got %q", senders.String())
+	}
+}
+
+// Datagrams longer than MaxSize are cut with a warning, MTU sized ones
+// arrive whole
+func TestReceiveTruncationWarning(t *testing.T) {
+	const mtu = 1472
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer conn.Close()
+
+	var logs bytes.Buffer
+	out := newCollector()
+	p := &Printer{Output: out, Format: FormatLine, MaxSize: mtu, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	go Receive(conn, p)
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	sender.Write(bytes.Repeat([]byte("a"), mtu))
+	out.wait(t)
+	sender.Write(bytes.Repeat([]byte("b"), mtu+100))
+	out.wait(t)
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(out.data.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out.data.String())
+	}
+	if strings.Contains(lines[0], "truncated") || !strings.Contains(lines[0], " 1472 ") {
+		t.Errorf("expected the MTU sized datagram whole, got %.60q", lines[0])
+	}
+	if !strings.Contains(lines[1], " 1472 truncated ") {
+		t.Errorf("expected the longer datagram truncated, got %.60q", lines[1])
+	}
+	if !strings.Contains(logs.String(), "datagram truncated") {
+		t.Errorf("expected a truncation warning, got %q", logs.String())
+	}
+}
+
+// io.Copy from a connected socket prints every datagram once and detects
+// truncation through ReadFrom
+func TestPrinterWriterReadsWholeDatagrams(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer server.Close()
+	client, err := net.DialUDP("udp4", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	sizes := []int{40000, 10, 0, 50000}
+	for _, n := range sizes {
+		server.WriteTo(bytes.Repeat([]byte("z"), n), client.LocalAddr())
+	}
+
+	var out, logs bytes.Buffer
+	p := &Printer{Output: &out, Format: FormatHex, MaxSize: 45000, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	context.AfterFunc(ctx, func() { client.Close() })
+	io.Copy(p.Writer(server.LocalAddr()), client)
+
+	want := hex.Dump(bytes.Repeat([]byte("z"), 40000)) + hex.Dump([]byte("zzzzzzzzzz")) + hex.Dump(nil) + hex.Dump(bytes.Repeat([]byte("z"), 45000))
+	if out.String() != want {
+		t.Errorf("expected dumps of 40000, 10, 0 and 45000 bytes, got %d bytes of output", out.Len())
+	}
+	if strings.Count(logs.String(), "datagram truncated") != 1 {
+		t.Errorf("expected one truncation warning, got %q", logs.String())
+	}
+}
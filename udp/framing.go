@@ -0,0 +1,175 @@
+package udp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+
+	"github.com/gppmad/gonc/logging"
+)
+
+// MaxPayload is the largest payload of an IPv4 UDP datagram, longer
+// frames are truncated
+const MaxPayload = 65507
+
+// Framing is how an input stream is cut into datagrams
+type Framing int
+
+const (
+	// FramePerRead sends what every read of the input returns, the default
+	FramePerRead Framing = iota
+	// FramePerLine sends every line without its line ending
+	FramePerLine
+	// FrameFixed sends every Size bytes, the last datagram may be shorter
+	FrameFixed
+	// FrameLengthPrefixed reads a 2 byte big endian length before every datagram
+	FrameLengthPrefixed
+)
+
+// ParseFraming parses read, line, length or a size in bytes for fixed
+// framing. The size is 0 for the other framings.
+func ParseFraming(s string) (Framing, int, error) {
+	switch s {
+	case "", "read":
+		return FramePerRead, 0, nil
+	case "line":
+		return FramePerLine, 0, nil
+	case "length":
+		return FrameLengthPrefixed, 0, nil
+	}
+
+	size, err := strconv.Atoi(s)
+	if err != nil || size <= 0 || size > MaxPayload {
+		return 0, 0, fmt.Errorf("invalid framing %q, expected read, line, length or a size from 1 to %d", s, MaxPayload)
+	}
+	return FrameFixed, size, nil
+}
+
+// FrameReader cuts an input stream into datagrams
+type FrameReader struct {
+	r       *bufio.Reader
+	framing Framing
+	size    int
+	pending []byte
+
+	// Logger receives a warning for every truncated datagram, nil discards them
+	Logger *slog.Logger
+}
+
+// NewFrameReader cuts r with framing, size is the length of FrameFixed datagrams
+func NewFrameReader(r io.Reader, framing Framing, size int) *FrameReader {
+	return &FrameReader{r: bufio.NewReaderSize(r, MaxPayload), framing: framing, size: size}
+}
+
+// Next returns the next datagram, io.EOF once the input is exhausted
+func (f *FrameReader) Next() ([]byte, error) {
+	switch f.framing {
+	case FramePerLine:
+		line, err := f.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-len(lineEnding(line))]
+		return f.truncate(line), nil
+
+	case FrameFixed:
+		buf := make([]byte, f.size)
+		n, err := io.ReadFull(f.r, buf)
+		if n == 0 {
+			return nil, err
+		}
+		return buf[:n], nil
+
+	case FrameLengthPrefixed:
+		var prefix [2]byte
+		if _, err := io.ReadFull(f.r, prefix[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, errors.New("length-prefixed input ends inside a length")
+			}
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+		if _, err := io.ReadFull(f.r, buf); err != nil {
+			return nil, fmt.Errorf("length-prefixed input ends inside a %d byte datagram", len(buf))
+		}
+		return f.truncate(buf), nil
+	}
+
+	buf := make([]byte, MaxPayload)
+	n, err := f.r.Read(buf)
+	if n == 0 {
+		if err == nil {
+			return f.Next()
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// lineEnding returns the "\n" or "\r\n" at the end of line
+func lineEnding(line []byte) []byte {
+	n := len(line)
+	switch {
+	case n >= 2 && line[n-2] == '\r' && line[n-1] == '\n':
+		return line[n-2:]
+	case n >= 1 && line[n-1] == '\n':
+		return line[n-1:]
+	}
+	return nil
+}
+
+// truncate cuts datagrams longer than MaxPayload with a warning
+func (f *FrameReader) truncate(datagram []byte) []byte {
+	if len(datagram) <= MaxPayload {
+		return datagram
+	}
+	logging.OrDiscard(f.Logger).Warn("datagram truncated", "size", len(datagram), "max", MaxPayload)
+	return datagram[:MaxPayload]
+}
+
+// Read returns one datagram per call when p can hold it, the rest of a
+// longer one on the following calls
+func (f *FrameReader) Read(p []byte) (int, error) {
+	if len(f.pending) == 0 {
+		datagram, err := f.Next()
+		if err != nil {
+			return 0, err
+		}
+		f.pending = datagram
+		if len(datagram) == 0 {
+			return 0, nil
+		}
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// WriteTo writes every datagram with a single Write, io.Copy uses it so
+// datagrams are never split or merged
+func (f *FrameReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		datagram := f.pending
+		f.pending = nil
+		if len(datagram) == 0 {
+			var err error
+			if datagram, err = f.Next(); err != nil {
+				if errors.Is(err, io.EOF) {
+					return written, nil
+				}
+				return written, err
+			}
+		}
+
+		n, err := w.Write(datagram)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
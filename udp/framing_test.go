@@ -0,0 +1,154 @@
+package udp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frames returns every datagram of input cut with framing
+func frames(t *testing.T, f *FrameReader) []string {
+	t.Helper()
+	var got []string
+	for {
+		datagram, err := f.Next()
+		if errors.Is(err, io.EOF) {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, string(datagram))
+	}
+}
+
+func TestParseFraming(t *testing.T) {
+	tests := []struct {
+		in      string
+		framing Framing
+		size    int
+		wantErr bool
+	}{
+		{"", FramePerRead, 0, false},
+		{"read", FramePerRead, 0, false},
+		{"line", FramePerLine, 0, false},
+		{"length", FrameLengthPrefixed, 0, false},
+		{"1472", FrameFixed, 1472, false},
+		{"0", 0, 0, true},
+		{"65508", 0, 0, true},
+		{"packet", 0, 0, true},
+	}
+	for _, tt := range tests {
+		framing, size, err := ParseFraming(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFraming(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if framing != tt.framing || size != tt.size {
+			t.Errorf("ParseFraming(%q) = %v, %d, want %v, %d", tt.in, framing, size, tt.framing, tt.size)
+		}
+	}
+}
+
+func TestFramePerLine(t *testing.T) {
+	f := NewFrameReader(strings.NewReader("one\r\ntwo\n\nthree"), FramePerLine, 0)
+	got := frames(t, f)
+	want := []string{"one", "two", "", "three"}
+	if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestFrameLengthPrefixed(t *testing.T) {
+	input := []byte{0, 2, 'h', 'i', 0, 0, 0, 3, 'y', 'o', 'u'}
+	got := frames(t, NewFrameReader(bytes.NewReader(input), FrameLengthPrefixed, 0))
+	if len(got) != 3 || got[0] != "hi" || got[1] != "" || got[2] != "you" {
+		t.Errorf("expected hi, an empty datagram and you, got %q", got)
+	}
+
+	f := NewFrameReader(bytes.NewReader([]byte{0, 5, 'a', 'b'}), FrameLengthPrefixed, 0)
+	if _, err := f.Next(); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("expected an error for input ending inside a datagram, got %v", err)
+	}
+}
+
+// Lines longer than a datagram can carry are cut with a warning
+func TestFrameTruncationWarning(t *testing.T) {
+	var logs bytes.Buffer
+	line := strings.Repeat("x", MaxPayload+10)
+	f := NewFrameReader(strings.NewReader(line+"\nshort\n"), FramePerLine, 0)
+	f.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	got := frames(t, f)
+	if len(got) != 2 || len(got[0]) != MaxPayload || got[1] != "short" {
+		t.Fatalf("expected a %d byte datagram and short, got %d datagrams", MaxPayload, len(got))
+	}
+	if !strings.Contains(logs.String(), "datagram truncated") || !strings.Contains(logs.String(), "size=65517") {
+		t.Errorf("expected a truncation warning, got %q", logs.String())
+	}
+}
+
+// Fixed framing cuts the input into MTU sized datagrams that cross a real
+// socket whole
+func TestFixedFramingMTUSizedPackets(t *testing.T) {
+	const mtu = 1472
+	input := bytes.Repeat([]byte("0123456789abcdef"), 200) // 3200 bytes
+
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer server.Close()
+	client, err := net.DialUDP("udp4", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// io.Copy hands the whole datagram to every Write through WriteTo
+	f := NewFrameReader(bytes.NewReader(input), FrameFixed, mtu)
+	if _, err := io.Copy(client, f); err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var sizes []int
+	var received []byte
+	buf := make([]byte, MaxDatagram)
+	for len(received) < len(input) {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d of %d bytes: %v", len(received), len(input), err)
+		}
+		sizes = append(sizes, n)
+		received = append(received, buf[:n]...)
+	}
+	if len(sizes) != 3 || sizes[0] != mtu || sizes[1] != mtu || sizes[2] != len(input)-2*mtu {
+		t.Errorf("expected datagrams of %d, %d and %d bytes, got %v", mtu, mtu, len(input)-2*mtu, sizes)
+	}
+	if !bytes.Equal(received, input) {
+		t.Error("received datagrams differ from the input")
+	}
+}
+
+// Read hands out the rest of a datagram when the buffer is too small
+func TestFrameReaderRead(t *testing.T) {
+	f := NewFrameReader(strings.NewReader("abcdef\ngh\n"), FramePerLine, 0)
+	buf := make([]byte, 4)
+	var got []string
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			break
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if strings.Join(got, "|") != "abcd|ef|gh" {
+		t.Errorf("expected abcd|ef|gh, got %q", strings.Join(got, "|"))
+	}
+}
@@ -0,0 +1,124 @@
+// Package udp exchanges datagrams over unconnected sockets, as multicast
+// groups and broadcasts need: replies may come from any address
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/gppmad/gonc/sockopt"
+)
+
+// Options configure the socket sending to a group or a broadcast address
+type Options struct {
+	// Interface sends multicast datagrams through this interface, the
+	// routing table picks one when nil
+	Interface *net.Interface
+
+	// TTL is the hop limit of multicast datagrams, 0 keeps the system
+	// default of 1
+	TTL int
+
+	// Loopback delivers multicast datagrams to listeners on this host too
+	Loopback bool
+
+	// Broadcast allows sending to broadcast addresses
+	Broadcast bool
+}
+
+// Join listens on the port of group and joins the group on iface, on the
+// default multicast interface when nil
+func Join(group *net.UDPAddr, iface *net.Interface) (*net.UDPConn, error) {
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast address", group.IP)
+	}
+	return net.ListenMulticastUDP(network(group.IP), iface, group)
+}
+
+// Dial opens a socket sending to target with opts. It is not connected so
+// datagrams are received from any address.
+func Dial(ctx context.Context, target *net.UDPAddr, opts Options) (*net.UDPConn, error) {
+	var controls []sockopt.Control
+	if target.IP.IsMulticast() {
+		controls = append(controls, sockopt.MulticastLoopback(opts.Loopback))
+		if opts.TTL > 0 {
+			controls = append(controls, sockopt.MulticastTTL(opts.TTL))
+		}
+		if opts.Interface != nil {
+			controls = append(controls, sockopt.MulticastInterface(opts.Interface))
+		}
+	}
+	if opts.Broadcast {
+		controls = append(controls, sockopt.Broadcast)
+	}
+
+	config := net.ListenConfig{Control: sockopt.Chain(controls...)}
+	pc, err := config.ListenPacket(ctx, network(target.IP), ":0")
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// network returns the UDP network of the family of ip
+func network(ip net.IP) string {
+	if ip.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// Receive prints every datagram of conn with p. It returns nil once conn
+// is closed.
+func Receive(conn net.PacketConn, p *Printer) error {
+	size := p.maxSize()
+	buf := make([]byte, size+1)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if err := p.Print(buf[:min(n, size)], from, n > size); err != nil {
+			return err
+		}
+	}
+}
+
+// Exchange sends every datagram of input to target and prints the replies
+// like Receive does, until ctx is done. The end of input does not stop it,
+// replies may still be on their way.
+func Exchange(ctx context.Context, conn *net.UDPConn, target *net.UDPAddr, input *FrameReader, p *Printer) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			datagram, err := input.Next()
+			if err == nil {
+				_, err = conn.WriteTo(datagram, target)
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					sendErr <- err
+					conn.Close()
+				}
+				return
+			}
+		}
+	}()
+
+	err := Receive(conn, p)
+	select {
+	case serr := <-sendErr:
+		return serr
+	default:
+	}
+	return err
+}
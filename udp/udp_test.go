@@ -0,0 +1,171 @@
+package udp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the datagrams and senders seen by Receive
+type collector struct {
+	mu      sync.Mutex
+	data    bytes.Buffer
+	senders []string
+	got     chan struct{}
+}
+
+func newCollector() *collector {
+	return &collector{got: make(chan struct{}, 16)}
+}
+
+func (c *collector) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.got <- struct{}{}
+	return c.data.Write(p)
+}
+
+// printer prints the datagrams to c and their senders to c.senders
+func (c *collector) printer() *Printer {
+	return &Printer{Output: c, Senders: senderWriter{c}}
+}
+
+type senderWriter struct{ c *collector }
+
+func (w senderWriter) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	var from string
+	var n int
+	fmt.Sscanf(string(p), "Datagram from %s %d bytes", &from, &n)
+	w.c.senders = append(w.c.senders, strings.TrimSuffix(from, ","))
+	return len(p), nil
+}
+
+func (c *collector) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no datagram received")
+	}
+}
+
+// Replies from another address than the target reach the unconnected socket
+func TestExchangeAcceptsAnySender(t *testing.T) {
+	target, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot listen on loopback: %v", err)
+	}
+	defer target.Close()
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+	go func() {
+		buf := make([]byte, MaxDatagram)
+		n, from, err := target.ReadFrom(buf)
+		if err == nil {
+			responder.WriteTo(bytes.ToUpper(buf[:n]), from)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := Dial(ctx, target.LocalAddr().(*net.UDPAddr), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := newCollector()
+	done := make(chan error, 1)
+	go func() {
+		done <- Exchange(ctx, conn, target.LocalAddr().(*net.UDPAddr), NewFrameReader(bytes.NewReader([]byte("hello")), FramePerRead, 0), out.printer())
+	}()
+	out.wait(t)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Exchange returned %v", err)
+	}
+
+	if out.data.String() != "HELLO" {
+		t.Errorf("expected the reply, got %q", out.data.String())
+	}
+	if len(out.senders) != 1 || out.senders[0] != responder.LocalAddr().String() {
+		t.Errorf("expected the reply from %v, got %v", responder.LocalAddr(), out.senders)
+	}
+}
+
+func TestMulticastLoopback(t *testing.T) {
+	lo, err := loopbackInterface()
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 77, 1)}
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.Port = probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	listener, err := Join(group, lo)
+	if err != nil {
+		t.Skipf("cannot join a multicast group on %s: %v", lo.Name, err)
+	}
+	defer listener.Close()
+	out := newCollector()
+	go Receive(listener, out.printer())
+
+	sender, err := Dial(context.Background(), group, Options{Interface: lo, TTL: 1, Loopback: true})
+	if err != nil {
+		t.Skipf("cannot send multicast on %s: %v", lo.Name, err)
+	}
+	defer sender.Close()
+	if _, err := sender.WriteTo([]byte("discover"), group); err != nil {
+		t.Skipf("multicast is not routed here: %v", err)
+	}
+
+	select {
+	case <-out.got:
+	case <-time.After(time.Second):
+		t.Skip("multicast datagrams are not delivered on loopback here")
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.data.String() != "discover" {
+		t.Errorf("expected the datagram sent to the group, got %q", out.data.String())
+	}
+
+	// The sender is bound to the wildcard address, only its port is known
+	_, port, _ := net.SplitHostPort(sender.LocalAddr().String())
+	if len(out.senders) != 1 || !strings.HasSuffix(out.senders[0], ":"+port) {
+		t.Errorf("expected the datagram from port %s, got %v", port, out.senders)
+	}
+}
+
+func loopbackInterface() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, net.UnknownNetworkError("loopback")
+}
+
+func TestJoinRejectsUnicast(t *testing.T) {
+	if _, err := Join(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}, nil); err == nil {
+		t.Error("expected an error joining a unicast address")
+	}
+}